@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
@@ -13,7 +14,8 @@ import (
 	"github.com/spf13/viper"
 	"github.com/wasilak/cloudflare-ddns/libs"
 	"github.com/wasilak/cloudflare-ddns/libs/api"
-	"github.com/wasilak/cloudflare-ddns/libs/ip"
+	"github.com/wasilak/cloudflare-ddns/libs/events"
+	"github.com/wasilak/cloudflare-ddns/libs/storage"
 	"github.com/wasilak/cloudflare-ddns/libs/web"
 	"github.com/wasilak/loggergo"
 )
@@ -28,7 +30,9 @@ var daemonCmd = &cobra.Command{
 	Short: "Run as a daemon",
 	PreRun: func(cmd *cobra.Command, args []string) {
 		cmd.SetContext(ctx)
+		api.DryRun = dryRun
 	},
+	PersistentPreRunE: providerPreRun,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if err := daemonFunc(cmd.Context()); err != nil {
 			return err
@@ -40,6 +44,67 @@ var daemonCmd = &cobra.Command{
 // This function runs a daemon that periodically refreshes DNS and notifies if the IP address has
 // changed.
 func daemonFunc(ctx context.Context) error {
+	var err error
+
+	// Records are loaded and validated before anything else is started, so the daemon refuses to
+	// start with an invalid configuration.
+	closeStorage, err := openStorage(storage.Options{})
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+
+	records, err := libs.PrepareRecords(ctx)
+	if err != nil {
+		return err
+	}
+	api.Records.Replace(*records)
+
+	api.IPLists, err = libs.PrepareIPLists()
+	if err != nil {
+		return err
+	}
+
+	// The reconcile interval is independent of dnsRefreshTime: checking the IP is cheap, comparing every
+	// record against the provider is not. A zero interval disables reconciliation.
+	reconcileInterval, err := time.ParseDuration(viper.GetString("reconcileInterval"))
+	if err != nil {
+		return fmt.Errorf("invalid reconcileInterval: %w", err)
+	}
+
+	var authConfig web.AuthConfig
+	if err := viper.UnmarshalKey("auth", &authConfig); err != nil {
+		return fmt.Errorf("invalid auth configuration: %w", err)
+	}
+	if err := authConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid auth configuration: %w", err)
+	}
+
+	var dynDNSConfig web.DynDNSConfig
+	if err := viper.UnmarshalKey("dyndns", &dynDNSConfig); err != nil {
+		return fmt.Errorf("invalid dyndns configuration: %w", err)
+	}
+	if err := dynDNSConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid dyndns configuration: %w", err)
+	}
+
+	var tlsConfig web.TLSConfig
+	if err := viper.UnmarshalKey("tls", &tlsConfig); err != nil {
+		return fmt.Errorf("invalid tls configuration: %w", err)
+	}
+	if err := tlsConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid tls configuration: %w", err)
+	}
+
+	trustedProxies, err := web.ParseTrustedProxies(viper.GetStringSlice("trusted_proxies"))
+	if err != nil {
+		return fmt.Errorf("invalid trusted_proxies: %w", err)
+	}
+
+	if viper.GetInt("readiness.failure_threshold") < 1 {
+		return fmt.Errorf("invalid readiness.failure_threshold: must be at least 1")
+	}
+
 	ctx, cancel := context.WithCancel(ctx)
 
 	// This code is parsing the value of the `dnsRefreshTime` configuration parameter from the Viper
@@ -51,12 +116,23 @@ func daemonFunc(ctx context.Context) error {
 		panic(err)
 	}
 
-	slog.DebugContext(ctx, "Refresh Time", "dnsRefreshTime", dnsRefreshTime)
+	slog.DebugContext(ctx, "Refresh Time", "dnsRefreshTime", dnsRefreshTime, "reconcileInterval", reconcileInterval)
+
+	// Cycles are run by the refresher, whether triggered by the tickers or through the REST API, so
+	// they never overlap.
+	refresher := libs.NewRefresher()
 
 	frameworkOptions := web.FrameworkOptions{
-		ListenAddr:     viper.GetString("listen-addr"),
-		OtelEnabled:    viper.GetBool("otel-enabled"),
-		LogLevelConfig: loggergo.GetLogLevelAccessor(),
+		ListenAddr:        viper.GetString("listen-addr"),
+		OtelEnabled:       viper.GetBool("otel-enabled"),
+		LogLevelConfig:    loggergo.GetLogLevelAccessor(),
+		Auth:              authConfig,
+		DynDNS:            dynDNSConfig,
+		TLS:               tlsConfig,
+		MetricsListenAddr: viper.GetString("metrics.listen_addr"),
+		Refresher:         refresher,
+		FailureThreshold:  viper.GetInt("readiness.failure_threshold"),
+		TrustedProxies:    trustedProxies,
 	}
 
 	server := &web.Server{WebServer: &web.WebServer{
@@ -95,6 +171,7 @@ func daemonFunc(ctx context.Context) error {
 					slog.DebugContext(ctx, "Reloading config...")
 					if err := viper.ReadInConfig(); err == nil {
 						slog.DebugContext(ctx, "Using config file", "filename", viper.ConfigFileUsed())
+						events.Publish(events.Event{Type: events.TypeConfigReloaded})
 					} else {
 						slog.ErrorContext(ctx, "Error", "error", err)
 					}
@@ -110,44 +187,57 @@ func daemonFunc(ctx context.Context) error {
 		}
 	}()
 
-	ip.CurrentIp, err = ip.GetIP(ctx)
-	if err == nil {
-		slog.DebugContext(ctx, "External IP", "ip", ip.CurrentIp.IP, "ip_source", ip.CurrentIp.Source.GetName())
+	// initial run
+	if _, err := refresher.Refresh(ctx, libs.RefreshOptions{}); err != nil {
+		slog.ErrorContext(ctx, "Initial refresh failed", "error", err)
 	}
 
-	api.Records = libs.PrepareRecords()
+	refreshTicker := time.NewTicker(dnsRefreshTime)
+	defer refreshTicker.Stop()
+	refresher.SetNextRun(time.Now().Add(dnsRefreshTime))
 
-	// initial run
-	runRunner()
+	var reconcileTick <-chan time.Time
+	if reconcileInterval > 0 {
+		reconcileTicker := time.NewTicker(reconcileInterval)
+		defer reconcileTicker.Stop()
+		reconcileTick = reconcileTicker.C
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
-		case <-time.Tick(dnsRefreshTime):
-			current_ip, err := ip.GetIP(ctx)
+		case <-reconcileTick:
+			// Every record is compared against its desired state, repairing records edited or deleted
+			// outside of this instance. Records already up to date are left untouched.
+			slog.DebugContext(ctx, "Reconciling records...")
+			result, err := refresher.Refresh(ctx, libs.RefreshOptions{Force: true})
 			if err != nil {
-				// Log the error and continue
-				slog.WarnContext(ctx, "Failed to get external IP", "error", err)
-				continue // try again next tick
+				slog.WarnContext(ctx, "Reconciliation failed", "error", err)
+				continue
+			}
+
+			repaired := 0
+			for _, recordResult := range result.Records {
+				if recordResult.Action == api.ActionCreated || recordResult.Action == api.ActionUpdated {
+					repaired++
+				}
+			}
+			if repaired > 0 && !result.IPChanged {
+				slog.InfoContext(ctx, "Reconciliation repaired drifted records", "records", repaired)
 			}
+		case <-refreshTicker.C:
+			refresher.SetNextRun(time.Now().Add(dnsRefreshTime))
 
-			if current_ip != nil && ip.CurrentIp.IP != current_ip.IP {
-				ip.CurrentIp = current_ip
-				libs.Notify(ctx, current_ip.IP)
-				runRunner()
+			// The IP address is checked and records are updated when it changed. Updates that failed
+			// during previous cycles are retried on every tick until they succeed.
+			if _, err := refresher.Refresh(ctx, libs.RefreshOptions{}); err != nil {
+				slog.WarnContext(ctx, "Refresh failed", "error", err)
 			}
 		}
 	}
 }
 
-func runRunner() {
-	slog.DebugContext(ctx, "Starting DNS refresh...")
-
-	err := libs.Runner(ctx, api.Records)
-	if err != nil {
-		slog.With("currentIp").ErrorContext(ctx, "Error", "error", err)
-	}
-
-	slog.DebugContext(ctx, "DNS refresh completed.")
+func init() {
+	daemonCmd.Flags().BoolVar(&dryRun, "dry-run", false, "log what would change without applying it")
 }
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/wasilak/cloudflare-ddns/libs/web"
+)
+
+var hashBcrypt bool
+
+// The hash command prints the hash of an API token or password, to be used as the `hash` of a
+// credential in the `auth` configuration. The secret is read from the standard input when not given
+// as an argument, keeping it out of the shell history.
+var hashCmd = &cobra.Command{
+	Use:   "hash [secret]",
+	Short: "Hash an API token or password for the auth configuration",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var secret string
+		if len(args) == 1 {
+			secret = args[0]
+		} else {
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				return err
+			}
+			secret = strings.TrimRight(line, "\r\n")
+		}
+
+		if secret == "" {
+			return fmt.Errorf("secret is empty")
+		}
+
+		hash, err := web.HashSecret(secret, hashBcrypt)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(hash)
+		return nil
+	},
+}
+
+func init() {
+	hashCmd.Flags().BoolVar(&hashBcrypt, "bcrypt", false, "use bcrypt, recommended for passwords")
+}
@@ -2,14 +2,22 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"log/slog"
+	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/wasilak/cloudflare-ddns/libs"
 	"github.com/wasilak/cloudflare-ddns/libs/api"
 	"github.com/wasilak/cloudflare-ddns/libs/ip"
+	"github.com/wasilak/cloudflare-ddns/libs/storage"
 )
 
+// storageLockTimeout bounds the wait for the storage when it is held by another process, usually a
+// running daemon which keeps it until it stops.
+const storageLockTimeout = time.Second
+
 // This code defines a Cobra command called "oneoff" which can be executed from the command line. The
 // command has a short description "Run once and exit". It also has a PreRun function that sets the
 // context of the command to the context passed in as an argument. The RunE function executes the
@@ -20,7 +28,9 @@ var oneoffCmd = &cobra.Command{
 	Short: "Run once and exit",
 	PreRun: func(cmd *cobra.Command, args []string) {
 		cmd.SetContext(ctx)
+		api.DryRun = dryRun
 	},
+	PersistentPreRunE: providerPreRun,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if err := oneOffFunc(cmd.Context()); err != nil {
 			return err
@@ -30,19 +40,53 @@ var oneoffCmd = &cobra.Command{
 }
 
 // The function calls the Runner function from the libs package and returns any errors encountered.
+// In dry-run mode it prints the plan instead of applying it.
 func oneOffFunc(ctx context.Context) error {
-	var err error
-	ip.CurrentIp, err = ip.GetIP(ctx)
+	current, err := ip.GetIP(ctx)
 	if err != nil {
 		return err
 	} else {
-		slog.DebugContext(ctx, "External IP", "ip", ip.CurrentIp.IP, "ip_source", ip.CurrentIp.Source.GetName())
+		ip.SetCurrent(current)
+		slog.DebugContext(ctx, "External IP", "ip", current.IP, "ip_source", current.Source.GetName())
 	}
 
-	api.Records = libs.PrepareRecords()
-	err = libs.Runner(ctx, api.Records)
+	// The storage is only read when planning. A running daemon holds it for as long as it runs, the
+	// records created through its REST API are then left to it.
+	closeStorage, err := openStorage(storage.Options{ReadOnly: api.DryRun, Timeout: storageLockTimeout})
+	if errors.Is(err, storage.ErrLocked) {
+		slog.WarnContext(ctx, "Storage in use by a running daemon, skipping the records created through the REST API", "error", err)
+		closeStorage, err = func() {}, nil
+	}
 	if err != nil {
 		return err
 	}
-	return nil
+	defer closeStorage()
+
+	records, err := libs.PrepareRecords(ctx)
+	if err != nil {
+		return err
+	}
+	api.Records.Replace(*records)
+
+	api.IPLists, err = libs.PrepareIPLists()
+	if err != nil {
+		return err
+	}
+
+	result, err := libs.Runner(ctx, api.Records.Snapshot(), api.IPLists)
+
+	// In dry-run mode the plan is the outcome of the command, so it is printed even if some records
+	// could not be planned.
+	if api.DryRun {
+		if printErr := libs.PrintPlan(os.Stdout, ip.Current().IP, result, outputFormat); printErr != nil {
+			return printErr
+		}
+	}
+
+	return err
+}
+
+func init() {
+	oneoffCmd.Flags().BoolVar(&dryRun, "dry-run", false, "show what would change without applying it")
+	oneoffCmd.Flags().StringVarP(&outputFormat, "output", "o", libs.PlanFormatText, "plan output format in dry-run mode: text or json")
 }
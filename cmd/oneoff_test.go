@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/wasilak/cloudflare-ddns/libs/api"
+	"github.com/wasilak/cloudflare-ddns/libs/cf"
+	"github.com/wasilak/cloudflare-ddns/libs/cf/cftest"
+	"github.com/wasilak/cloudflare-ddns/libs/ip"
+)
+
+// The function configures the commands to manage the given records against a fake Cloudflare API
+// holding the example.com zone, with 203.0.113.10 as the external IP. The configuration and the globals
+// of the api package are restored when the test ends.
+func setupOneoff(t *testing.T, records ...map[string]any) (*cftest.Server, string) {
+	t.Helper()
+
+	fake := cftest.NewServer()
+	t.Cleanup(fake.Close)
+	zone := fake.AddZone("example.com")
+
+	transport := cftest.NewIPTransport("203.0.113.10")
+	dnsProvider, storedRecords, ipLists, currentIP := api.DNSProvider, api.Records, api.IPLists, ip.Current()
+	http.DefaultTransport = transport
+	api.Records, api.IPLists = api.NewRecordStore(nil), &[]cf.IPList{}
+	t.Cleanup(func() {
+		http.DefaultTransport = transport.Next
+		api.DNSProvider, api.Records, api.IPLists = dnsProvider, storedRecords, ipLists
+		ip.SetCurrent(currentIP)
+		api.DryRun = false
+		api.SetRecordHooks(nil)
+		viper.Reset()
+	})
+
+	viper.Reset()
+	viper.Set("provider", "cloudflare")
+	viper.Set("CF.APIKey", "key")
+	viper.Set("CF.APIEmail", "user@example.com")
+	viper.Set("CF.BaseURL", fake.BaseURL())
+	viper.Set("records", records)
+	if err := initProvider(); err != nil {
+		t.Fatal(err)
+	}
+
+	return fake, zone.ID
+}
+
+// The function returns the managed record definition of an A record of example.com.
+func aRecord(name string, ttl int) map[string]any {
+	return map[string]any{
+		"zone_name": "example.com",
+		"record":    map[string]any{"name": name, "type": "A", "ttl": ttl},
+	}
+}
+
+// The function returns the content of the records of the zone by name.
+func contents(fake *cftest.Server, zoneID string) map[string]string {
+	contents := map[string]string{}
+	for _, record := range fake.Records(zoneID) {
+		contents[record.Name] = record.Content
+	}
+	return contents
+}
+
+// The function returns the requests changing records received by the fake server.
+func writes(fake *cftest.Server) []string {
+	writes := []string{}
+	for _, request := range fake.Requests() {
+		if !strings.HasPrefix(request, http.MethodGet) {
+			writes = append(writes, request)
+		}
+	}
+	return writes
+}
+
+// The function returns the number of requests of the given method among writes.
+func count(writes []string, method string) int {
+	n := 0
+	for _, write := range writes {
+		if strings.HasPrefix(write, method+" ") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestOneoffCreatesUpdatesAndLeavesUnchanged(t *testing.T) {
+	fake, zoneID := setupOneoff(t,
+		aRecord("new.example.com", 1),
+		aRecord("stale.example.com", 1),
+		aRecord("current.example.com", 1),
+	)
+	fake.AddRecord(zoneID, cftest.Record{Name: "stale.example.com", Type: "A", Content: "198.51.100.1", TTL: 1})
+	fake.AddRecord(zoneID, cftest.Record{Name: "current.example.com", Type: "A", Content: "203.0.113.10", TTL: 1})
+
+	if err := oneOffFunc(context.Background()); err != nil {
+		t.Fatalf("oneoff: %v", err)
+	}
+
+	want := map[string]string{
+		"new.example.com":     "203.0.113.10",
+		"stale.example.com":   "203.0.113.10",
+		"current.example.com": "203.0.113.10",
+	}
+	if got := contents(fake, zoneID); !maps.Equal(got, want) {
+		t.Errorf("records %v, want %v", got, want)
+	}
+	if got := writes(fake); count(got, http.MethodPost) != 1 {
+		t.Errorf("writes %v, want a single POST creating new.example.com", got)
+	}
+
+	// A second run finds every record up to date and writes nothing.
+	before := len(writes(fake))
+	if err := oneOffFunc(context.Background()); err != nil {
+		t.Fatalf("second oneoff: %v", err)
+	}
+	if got := contents(fake, zoneID); !maps.Equal(got, want) {
+		t.Errorf("records %v after the second run, want %v", got, want)
+	}
+	if after := writes(fake)[before:]; len(after) != 0 {
+		t.Errorf("second run wrote %v", after)
+	}
+}
+
+func TestOneoffDryRunChangesNothing(t *testing.T) {
+	fake, zoneID := setupOneoff(t, aRecord("new.example.com", 1), aRecord("stale.example.com", 1))
+	fake.AddRecord(zoneID, cftest.Record{Name: "stale.example.com", Type: "A", Content: "198.51.100.1", TTL: 1})
+	api.DryRun = true
+
+	if err := oneOffFunc(context.Background()); err != nil {
+		t.Fatalf("oneoff --dry-run: %v", err)
+	}
+
+	if got := writes(fake); len(got) != 0 {
+		t.Errorf("dry run wrote %v", got)
+	}
+	if got := contents(fake, zoneID); !maps.Equal(got, map[string]string{"stale.example.com": "198.51.100.1"}) {
+		t.Errorf("records %v after a dry run, want them untouched", got)
+	}
+}
+
+func TestOneoffRetriesRateLimitedRequests(t *testing.T) {
+	fake, zoneID := setupOneoff(t, aRecord("home.example.com", 1))
+	// The client retries twice, honouring Retry-After.
+	fake.RateLimit(2)
+
+	if err := oneOffFunc(context.Background()); err != nil {
+		t.Fatalf("oneoff: %v", err)
+	}
+
+	if got := contents(fake, zoneID)["home.example.com"]; got != "203.0.113.10" {
+		t.Errorf("content %q, want 203.0.113.10", got)
+	}
+}
+
+func TestOneoffLeavesRecordsOnProviderErrors(t *testing.T) {
+	fake, zoneID := setupOneoff(t, aRecord("home.example.com", 1))
+	fake.AddRecord(zoneID, cftest.Record{Name: "home.example.com", Type: "A", Content: "198.51.100.1", TTL: 1})
+	fake.Fail(cftest.Failure{Method: http.MethodPut, PathPrefix: "zones/", Status: http.StatusBadRequest, Code: 9005, Message: "Content for A record is invalid."})
+
+	if err := oneOffFunc(context.Background()); !errors.Is(err, cf.ErrValidation) {
+		t.Errorf("oneoff: %v, want an error wrapping ErrValidation", err)
+	}
+
+	if got := contents(fake, zoneID)["home.example.com"]; got != "198.51.100.1" {
+		t.Errorf("content %q after a rejected update, want 198.51.100.1", got)
+	}
+}
+
+func TestOneoffMissingIPList(t *testing.T) {
+	setupOneoff(t, aRecord("home.example.com", 1))
+	viper.Set("ip_lists", []map[string]any{{"account_id": "account", "list_name": "missing", "comment": "cloudflare-ddns"}})
+
+	err := oneOffFunc(context.Background())
+	if !errors.Is(err, cf.ErrIPListNotFound) {
+		t.Fatalf("oneoff: %v, want an error wrapping ErrIPListNotFound", err)
+	}
+	if got := exitCode(err); got != ExitIPListNotFound {
+		t.Errorf("exit code %d, want %d", got, ExitIPListNotFound)
+	}
+}
+
+// The hooks of a record are read from the config only and run for that record, whose name is
+// normalized before they are looked up.
+func TestOneoffRunsRecordHooks(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "updated")
+	hooked := aRecord("home", 1)
+	hooked["hooks"] = map[string]any{"post_update": []map[string]any{{"command": []string{"sh", "-c", "echo $CFDDNS_RECORD_NAME >> " + marker}}}}
+	fake, zoneID := setupOneoff(t, hooked, aRecord("other.example.com", 1))
+
+	if err := oneOffFunc(context.Background()); err != nil {
+		t.Fatalf("oneoff: %v", err)
+	}
+
+	data, err := os.ReadFile(marker)
+	if err != nil {
+		t.Fatalf("the hook did not run: %v", err)
+	}
+	if got := string(data); got != "home.example.com\n" {
+		t.Errorf("hook ran for %q, want home.example.com only", got)
+	}
+	if got := contents(fake, zoneID)["home.example.com"]; got != "203.0.113.10" {
+		t.Errorf("content %q, want 203.0.113.10", got)
+	}
+}
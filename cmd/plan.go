@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/wasilak/cloudflare-ddns/libs"
+	"github.com/wasilak/cloudflare-ddns/libs/api"
+)
+
+// Flags shared by the commands able to only plan changes.
+var (
+	dryRun       bool
+	outputFormat string
+)
+
+// The plan command detects the external IP, fetches the current state of every configured record and
+// IP List and prints what would be created, updated or left unchanged, without changing anything. It
+// is the same as `oneoff --dry-run`.
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Show what would change without applying it",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		cmd.SetContext(ctx)
+		api.DryRun = true
+	},
+	PersistentPreRunE: providerPreRun,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return oneOffFunc(cmd.Context())
+	},
+}
+
+func init() {
+	planCmd.Flags().StringVarP(&outputFormat, "output", "o", libs.PlanFormatText, "output format: text or json")
+}
@@ -0,0 +1,223 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/wasilak/cloudflare-ddns/libs"
+	"github.com/wasilak/cloudflare-ddns/libs/cf"
+	"github.com/wasilak/cloudflare-ddns/libs/web"
+)
+
+var (
+	refreshForce   bool
+	refreshRecords []string
+)
+
+// The refresh command asks a running daemon to run a refresh cycle right away, through POST
+// /api/refresh, and prints its results the way the plan command does. The daemon is reached at
+// client.url (http://<listen> by default, https when tls is configured) with the client.token bearer
+// token or the client.username and client.password credentials. client.ca_file verifies the server
+// certificate, client.cert_file and client.key_file present a client certificate for mutual TLS.
+var refreshCmd = &cobra.Command{
+	Use:   "refresh",
+	Short: "Trigger a refresh cycle on a running daemon",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		cmd.SetContext(ctx)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		options := libs.RefreshOptions{Force: refreshForce}
+		for _, value := range refreshRecords {
+			selector, err := parseRecordSelector(value)
+			if err != nil {
+				return err
+			}
+			options.Records = append(options.Records, selector)
+		}
+
+		result, err := requestRefresh(options)
+		if err != nil {
+			return err
+		}
+
+		if err := libs.PrintPlan(os.Stdout, result.IP, result.RunResult, outputFormat); err != nil {
+			return err
+		}
+
+		failed := 0
+		for _, recordResult := range result.Records {
+			if recordResult.Error != "" {
+				failed++
+			}
+		}
+		for _, listResult := range result.IPLists {
+			if listResult.Error != "" {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d updates failed", failed)
+		}
+
+		return nil
+	},
+}
+
+// The function parses a record selector given as zone/name or zone/name/type.
+func parseRecordSelector(value string) (libs.RecordSelector, error) {
+	parts := strings.Split(value, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return libs.RecordSelector{}, fmt.Errorf("%w: invalid record %q, expected zone/name or zone/name/type", cf.ErrValidation, value)
+	}
+
+	selector := libs.RecordSelector{ZoneName: parts[0], Name: parts[1]}
+	if len(parts) == 3 {
+		selector.Type = parts[2]
+	}
+
+	return selector, nil
+}
+
+// The function sends the refresh request to the daemon and returns its result. Errors answered by the
+// daemon are mapped back to the cf errors, so the exit code tells them apart.
+func requestRefresh(options libs.RefreshOptions) (libs.RefreshResult, error) {
+	var result libs.RefreshResult
+
+	body, err := json.Marshal(options)
+	if err != nil {
+		return result, err
+	}
+
+	baseURL := viper.GetString("client.url")
+	if baseURL == "" {
+		scheme := "http://"
+		if viper.GetString("tls.cert_file") != "" {
+			scheme = "https://"
+		}
+		baseURL = scheme + viper.GetString("listen-addr")
+	}
+
+	tlsConfig, err := clientTLSConfig()
+	if err != nil {
+		return result, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(baseURL, "/")+"/api/refresh", bytes.NewReader(body))
+	if err != nil {
+		return result, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if token := viper.GetString("client.token"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if username := viper.GetString("client.username"); username != "" {
+		req.SetBasicAuth(username, viper.GetString("client.password"))
+	}
+
+	client := &http.Client{
+		Timeout:   viper.GetDuration("client.timeout"),
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return result, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var response web.ErrorResponse
+		if err := json.Unmarshal(data, &response); err != nil || response.Message == "" {
+			return result, fmt.Errorf("refresh failed: %s", resp.Status)
+		}
+		return result, &refreshError{response: response}
+	}
+
+	if err := json.Unmarshal(data, &result); err != nil {
+		return result, fmt.Errorf("invalid refresh response: %w", err)
+	}
+
+	return result, nil
+}
+
+// The function returns the TLS configuration of the client: the CAs of client.ca_file, the system ones
+// otherwise, and the client.cert_file certificate when set.
+func clientTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile := viper.GetString("client.ca_file"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+	}
+
+	if certFile := viper.GetString("client.cert_file"); certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, viper.GetString("client.key_file"))
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
+
+// refreshError is an error answered by the daemon, matching the cf error of the same kind.
+type refreshError struct {
+	response web.ErrorResponse
+}
+
+func (e *refreshError) Error() string {
+	return e.response.Message + ": " + e.response.Error
+}
+
+// The function returns the cf error matching the code of the answer, so the exit code tells the
+// errors apart the way it does for oneoff.
+func (e *refreshError) Unwrap() error {
+	switch e.response.Code {
+	case web.CodeValidationFailed, web.CodeInvalidRequest:
+		return cf.ErrValidation
+	case web.CodeUnauthorized, web.CodeForbidden, web.CodeProviderUnauthorized:
+		return cf.ErrUnauthorized
+	case web.CodeZoneNotFound:
+		return cf.ErrZoneNotFound
+	case web.CodeRecordNotFound:
+		return cf.ErrRecordNotFound
+	case web.CodeIPListNotFound:
+		return cf.ErrIPListNotFound
+	case web.CodeRateLimited:
+		return cf.ErrRateLimited
+	default:
+		return nil
+	}
+}
+
+func init() {
+	refreshCmd.Flags().BoolVar(&refreshForce, "force", false, "update the records even though the IP did not change")
+	refreshCmd.Flags().StringArrayVar(&refreshRecords, "record", nil, "only refresh this record, as zone/name or zone/name/type (repeatable)")
+	refreshCmd.Flags().StringVarP(&outputFormat, "output", "o", libs.PlanFormatText, "output format: text or json")
+	refreshCmd.Flags().String("url", "", "URL of the daemon (default http://<listen>, https with tls)")
+	refreshCmd.Flags().Duration("timeout", 5*time.Minute, "how long to wait for the cycle")
+
+	viper.BindPFlag("client.url", refreshCmd.Flags().Lookup("url"))
+	viper.BindPFlag("client.timeout", refreshCmd.Flags().Lookup("timeout"))
+}
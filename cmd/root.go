@@ -2,7 +2,9 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"strings"
 
@@ -11,8 +13,12 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/wasilak/cloudflare-ddns/libs"
 	"github.com/wasilak/cloudflare-ddns/libs/api"
 	"github.com/wasilak/cloudflare-ddns/libs/cf"
+	"github.com/wasilak/cloudflare-ddns/libs/hooks"
+	"github.com/wasilak/cloudflare-ddns/libs/rfc2136"
+	"github.com/wasilak/cloudflare-ddns/libs/storage"
 	"github.com/wasilak/loggergo"
 	loggergoLib "github.com/wasilak/loggergo/lib"
 	loggergoTypes "github.com/wasilak/loggergo/lib/types"
@@ -35,11 +41,43 @@ var (
 	ctx = context.Background()
 )
 
-// The function executes a root command and prints any errors to the standard error output.
+// Exit codes returned by the commands, telling the kind of failure apart for scripts and schedulers.
+const (
+	ExitError          = 1
+	ExitValidation     = 2
+	ExitUnauthorized   = 3
+	ExitZoneNotFound   = 4
+	ExitRecordNotFound = 5
+	ExitRateLimited    = 6
+	ExitIPListNotFound = 7
+)
+
+// The function executes a root command and prints any errors to the standard error output, exiting
+// with the code matching the error (see exitCode).
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
+	}
+}
+
+// The function returns the exit code matching an error.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, cf.ErrValidation):
+		return ExitValidation
+	case errors.Is(err, cf.ErrUnauthorized):
+		return ExitUnauthorized
+	case errors.Is(err, cf.ErrZoneNotFound):
+		return ExitZoneNotFound
+	case errors.Is(err, cf.ErrRecordNotFound):
+		return ExitRecordNotFound
+	case errors.Is(err, cf.ErrRateLimited):
+		return ExitRateLimited
+	case errors.Is(err, cf.ErrIPListNotFound):
+		return ExitIPListNotFound
+	default:
+		return ExitError
 	}
 }
 
@@ -48,7 +86,6 @@ func Execute() {
 // and running as a daemon.
 func init() {
 	cobra.OnInitialize(initConfig)
-	cobra.OnInitialize(initCF)
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.cloudflare-ddns/config.yml)")
 	rootCmd.PersistentFlags().String("listen", "127.0.0.1:3000", "listen address")
@@ -56,6 +93,9 @@ func init() {
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(oneoffCmd)
 	rootCmd.AddCommand(daemonCmd)
+	rootCmd.AddCommand(planCmd)
+	rootCmd.AddCommand(hashCmd)
+	rootCmd.AddCommand(refreshCmd)
 }
 
 // The function initializes the configuration settings for a Go program, including loading environment
@@ -67,12 +107,24 @@ func initConfig() {
 
 	viper.BindEnv("CF.APIKey", "CF_API_KEY")
 	viper.BindEnv("CF.APIEmail", "CF_API_EMAIL")
+	viper.BindEnv("CF.BaseURL", "CF_API_BASE_URL")
 
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 
 	viper.SetDefault("loglevel", "info")
 	viper.SetDefault("logformat", "text")
 	viper.SetDefault("dnsRefreshTime", "60s")
+	viper.SetDefault("reconcileInterval", "1h")
+	viper.SetDefault("readiness.failure_threshold", 3)
+	viper.SetDefault("auth.public_health", true)
+	viper.SetDefault("auth.public_metrics", true)
+	viper.SetDefault("provider", "cloudflare")
+	viper.SetDefault("minTTL", 60)
+	viper.SetDefault("owner", libs.GetAppName())
+	viper.SetDefault("rfc2136.net", "udp")
+	viper.SetDefault("rfc2136.timeout", "5s")
+	viper.SetDefault("rfc2136.ttl", 300)
+	viper.SetDefault("rfc2136.tsig.algorithm", "hmac-sha256.")
 	viper.SetDefault("mail.enabled", false)
 	viper.SetDefault("mail.from", "")
 	viper.SetDefault("mail.to", []string{""})
@@ -120,6 +172,11 @@ func initConfig() {
 		SetAsDefault: true,
 	}
 
+	// A JSON plan is meant to be parsed, so logs are kept out of the standard output.
+	if outputFormat == libs.PlanFormatJSON {
+		loggerConfig.OutputStream = os.Stderr
+	}
+
 	ctx, _, err := loggergo.Init(ctx, loggerConfig)
 	if err != nil {
 		slog.ErrorContext(ctx, "error", "msg", err)
@@ -129,8 +186,82 @@ func initConfig() {
 	viper.WithLogger(slog.Default())
 }
 
-func initCF() {
-	api.CfAPI = cf.CF{}
+// The function initializes the Cloudflare client and the DNS provider selected by the `provider`
+// configuration key and makes them available to the api package. It is run before the commands
+// managing records only, see providerPreRun, so the other ones work without a provider configuration.
+func initProvider() error {
+	api.CfAPI = &cf.CF{}
+	api.CfAPI.Init(viper.GetString("CF.APIKey"), viper.GetString("CF.APIEmail"), viper.GetString("CF.BaseURL"))
+
+	// Cloudflare flattens CNAME records at the zone apex, plain authoritative servers do not.
+	api.RecordValidation = cf.ValidationOptions{
+		MinTTL:          viper.GetInt("minTTL"),
+		CNAMEFlattening: viper.GetString("provider") == "cloudflare",
+	}
+	api.Owner = viper.GetString("owner")
+
+	if err := viper.UnmarshalKey("hooks", &api.Hooks); err != nil {
+		return fmt.Errorf("invalid hooks configuration: %w", err)
+	}
+	if err := hooks.Validate(api.Hooks.PreIPChange, api.Hooks.PostIPChange, api.Hooks.PreRecordUpdate, api.Hooks.PostRecordUpdate); err != nil {
+		return fmt.Errorf("invalid hooks configuration: %w", err)
+	}
+
+	switch viper.GetString("provider") {
+	case "cloudflare":
+		api.DNSProvider = api.CfAPI
+	case "rfc2136":
+		var config rfc2136.Config
+		if err := viper.UnmarshalKey("rfc2136", &config); err != nil {
+			return fmt.Errorf("invalid rfc2136 configuration: %w", err)
+		}
+		rfc2136API := &rfc2136.RFC2136{}
+		rfc2136API.Init(config)
+		api.DNSProvider = rfc2136API
+	default:
+		return fmt.Errorf("unknown DNS provider %q", viper.GetString("provider"))
+	}
+
+	return nil
+}
+
+// The function is the PersistentPreRunE of the commands managing records, initializing the DNS
+// provider they need.
+func providerPreRun(cmd *cobra.Command, args []string) error {
+	return initProvider()
+}
+
+// The function opens the storage of the records created through the REST API and makes it available
+// to the api package. Persistence is opt-in: nothing is stored unless `storage.path` is set, records
+// created through the REST API being lost on restart. The returned function closes it.
+// A read-only storage that does not exist yet holds no record, so it is not opened.
+func openStorage(options storage.Options) (func(), error) {
+	path := viper.GetString("storage.path")
+	if path == "" {
+		return func() {}, nil
+	}
+
+	store, err := storage.Open(path, options)
+	if options.ReadOnly && errors.Is(err, fs.ErrNotExist) {
+		return func() {}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pins, err := store.Pins()
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	slog.DebugContext(ctx, "Using storage", "path", path, "readOnly", options.ReadOnly, "pins", len(pins))
+	api.Storage = store
+	api.LoadPins(pins)
 
-	api.CfAPI.Init(viper.GetString("CF.APIKey"), viper.GetString("CF.APIEmail"))
+	return func() {
+		api.Storage = nil
+		api.LoadPins(nil)
+		store.Close()
+	}, nil
 }
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/wasilak/cloudflare-ddns/libs/api"
+	"github.com/wasilak/cloudflare-ddns/libs/cf"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{err: errors.New("failure"), want: ExitError},
+		{err: &cf.ValidationError{}, want: ExitValidation},
+		{err: cf.ErrUnauthorized, want: ExitUnauthorized},
+		{err: cf.ErrZoneNotFound, want: ExitZoneNotFound},
+		{err: cf.ErrRecordNotFound, want: ExitRecordNotFound},
+		{err: cf.ErrRateLimited, want: ExitRateLimited},
+		{err: cf.ErrIPListNotFound, want: ExitIPListNotFound},
+	}
+
+	for _, tt := range tests {
+		// Provider errors reach the commands wrapped.
+		err := fmt.Errorf("updating home.example.com: %w", tt.err)
+		if got := exitCode(err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, want %d", err, got, tt.want)
+		}
+	}
+}
+
+// An invalid provider configuration is returned to the command instead of exiting, and only the
+// commands managing records initialize the provider.
+func TestInitProviderErrors(t *testing.T) {
+	dnsProvider, cfAPI, globalHooks, validation, owner := api.DNSProvider, api.CfAPI, api.Hooks, api.RecordValidation, api.Owner
+	t.Cleanup(func() {
+		api.DNSProvider, api.CfAPI, api.Hooks, api.RecordValidation, api.Owner = dnsProvider, cfAPI, globalHooks, validation, owner
+		viper.Reset()
+	})
+
+	tests := []struct {
+		name   string
+		config map[string]any
+	}{
+		{name: "unknown provider", config: map[string]any{"provider": "route53"}},
+		{name: "invalid hooks", config: map[string]any{"provider": "cloudflare", "hooks": map[string]any{"pre_ip_change": []map[string]any{{}}}}},
+	}
+
+	for _, tt := range tests {
+		viper.Reset()
+		for key, value := range tt.config {
+			viper.Set(key, value)
+		}
+		if err := initProvider(); err == nil {
+			t.Errorf("%s: initProvider succeeded", tt.name)
+		}
+	}
+
+	for _, cmd := range []*cobra.Command{versionCmd, hashCmd, refreshCmd} {
+		if cmd.PersistentPreRunE != nil {
+			t.Errorf("%s initializes the provider", cmd.Name())
+		}
+	}
+}
@@ -2,175 +2,421 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"sync"
+	"time"
 
-	cloudflare "github.com/cloudflare/cloudflare-go/v4"
 	"github.com/cloudflare/cloudflare-go/v4/dns"
 	"github.com/wasilak/cloudflare-ddns/libs/cf"
+	"github.com/wasilak/cloudflare-ddns/libs/hooks"
 	"github.com/wasilak/cloudflare-ddns/libs/ip"
+	"github.com/wasilak/cloudflare-ddns/libs/storage"
 )
 
-var CfAPI cf.CF
-var Records = &[]cf.ExtendedCloudflareDNSRecord{}
+// DNSProvider is the backend used to manage records, selected from configuration at startup.
+var DNSProvider Provider
 
-// The function updates a DNS record in Cloudflare by either creating a new record or updating an
-// existing one.
-func RunDNSUpdate(ctx context.Context, record cf.ExtendedCloudflareDNSRecord) error {
+// Records holds the records managed by this instance.
+var Records = NewRecordStore(nil)
 
-	zoneID, err := CfAPI.GetZonesList(ctx, record.ZoneName)
+// Storage persists the records created through the REST API. Nil when persistence is disabled.
+var Storage *storage.Storage
+
+// RecordValidation holds the limits records are validated against before being sent to the provider.
+var RecordValidation = cf.ValidationOptions{MinTTL: 60, CNAMEFlattening: true}
+
+// Hooks are the global hooks, see the hooks package.
+var Hooks hooks.Hooks
+
+// recordHooksByKey holds the hooks of the configured records, keyed by
+// cf.ExtendedCloudflareDNSRecord.Key. They are kept apart from the records, which the DNS providers
+// and the REST API never see, as hooks run commands on the host and may hold secrets.
+var recordHooksByKey = struct {
+	sync.RWMutex
+	hooks map[string]hooks.RecordHooks
+}{hooks: map[string]hooks.RecordHooks{}}
+
+// DryRun, when set, makes every change only be planned and logged: nothing is written to the provider
+// or to the storage.
+var DryRun bool
+
+// Owner is the default comment tagging the records managed by this instance, telling them apart from
+// sibling records sharing the same name and type, e.g. round-robin A records managed elsewhere.
+var Owner = "cloudflare-ddns"
+
+// The function updates a DNS record through the configured provider by either creating a new record or updating an
+// existing one, and returns what was done. A record already holding the desired content, proxy
+// setting and TTL is left unchanged.
+func RunDNSUpdate(ctx context.Context, record cf.ExtendedCloudflareDNSRecord) (RecordResult, error) {
+	started := time.Now()
+	result := RecordResult{Record: record, NewContent: record.Record.Content}
+
+	zoneID, err := DNSProvider.GetZoneID(ctx, record.ZoneName)
 	if err != nil {
 		slog.With("record", record).ErrorContext(ctx, "Error", "error", err)
-		return err
+		result.finish(started, err)
+		return result, err
 	}
 
-	r, err := CfAPI.GetDNSRecord(ctx, record, zoneID)
+	r, err := GetDNSRecord(ctx, record, zoneID)
 	if err != nil {
-		return err
+		result.finish(started, err)
+		return result, err
+	}
+
+	switch {
+	case r == nil:
+		result.Action = ActionCreated
+	case upToDate(*r, record):
+		result.Action = ActionUnchanged
+		result.OldContent = r.Record.Content
+	default:
+		result.Action = ActionUpdated
+		result.OldContent = r.Record.Content
+	}
+
+	if DryRun {
+		slog.With(PrepareRecordForLoggiong("record", &record)).InfoContext(ctx, "Dry run, record not changed", "action", result.Action)
+		result.finish(started, nil)
+		return result, nil
+	}
+
+	if result.Action == ActionUnchanged {
+		// Keep the record ID, so the record is recognized later on.
+		r.Record.Comment = record.Record.Comment
+		r.Origin = record.Origin
+		Records.Upsert(*r)
+		result.finish(started, nil)
+		return result, nil
+	}
+
+	preHooks, postHooks := recordHooks(record)
+	event := recordEvent(hooks.StagePreRecordUpdate, record, result.Action)
+
+	if err := hooks.Run(ctx, preHooks, event); err != nil {
+		result.Action = ActionVetoed
+		result.Error = err.Error()
+		result.finish(started, nil)
+		return result, nil
 	}
 
-	if r == nil || r.Record == nil || r.Record.ID == "" {
-		AddRecord(ctx, &record)
+	if result.Action == ActionCreated {
+		_, err = AddRecord(ctx, &record)
 	} else {
-		UpdateRecord(ctx, &record)
+		_, err = UpdateRecord(ctx, &record)
 	}
 
-	return nil
+	result.NewContent = record.Record.Content
+	result.finish(started, err)
+
+	event.Stage = hooks.StagePostRecordUpdate
+	event.Result = "success"
+	if err != nil {
+		event.Result = "failure"
+		event.Error = err.Error()
+	}
+	hooks.Run(ctx, postHooks, event)
+
+	return result, err
 }
 
-func FindDNSRecordByName(recordName string) *cf.ExtendedCloudflareDNSRecord {
-	for _, r := range *Records {
-		if r.Record.Name == recordName {
-			return &r
-		}
+// The function replaces the hooks of the records, keyed by cf.ExtendedCloudflareDNSRecord.Key.
+func SetRecordHooks(recordHooks map[string]hooks.RecordHooks) {
+	recordHooksByKey.Lock()
+	defer recordHooksByKey.Unlock()
+
+	recordHooksByKey.hooks = maps.Clone(recordHooks)
+	if recordHooksByKey.hooks == nil {
+		recordHooksByKey.hooks = map[string]hooks.RecordHooks{}
 	}
-	return nil
 }
 
-func DeleteRecord(ctx context.Context, recordName string, zoneName string) (*cf.ExtendedCloudflareDNSRecord, error) {
-	var err error
+// recordHooks returns the pre and post update hooks of the record: the global ones followed by the
+// ones of the record itself.
+func recordHooks(record cf.ExtendedCloudflareDNSRecord) ([]hooks.Hook, []hooks.Hook) {
+	pre := append([]hooks.Hook{}, Hooks.PreRecordUpdate...)
+	post := append([]hooks.Hook{}, Hooks.PostRecordUpdate...)
+
+	recordHooksByKey.RLock()
+	own, ok := recordHooksByKey.hooks[record.Key()]
+	recordHooksByKey.RUnlock()
+
+	if ok {
+		pre = append(pre, own.PreUpdate...)
+		post = append(post, own.PostUpdate...)
+	}
+
+	return pre, post
+}
 
-	zoneID, err := CfAPI.GetZonesList(ctx, zoneName)
+// recordEvent returns the hook event for the update of the record.
+func recordEvent(stage string, record cf.ExtendedCloudflareDNSRecord, action string) hooks.Event {
+	event := hooks.Event{
+		Stage:      stage,
+		RecordName: record.Record.Name,
+		RecordType: string(record.Record.Type),
+		ZoneName:   record.ZoneName,
+		Action:     action,
+	}
+
+	if current := ip.Current(); current != nil {
+		event.NewIP = current.IP
+	}
+	if previous := ip.Previous(); previous != nil {
+		event.OldIP = previous.IP
+	}
+
+	return event
+}
+
+// upToDate reports whether the existing record already matches the desired one. TTL 1 (automatic)
+// matches any TTL, as providers without automatic TTL replace it with their default.
+func upToDate(existing, desired cf.ExtendedCloudflareDNSRecord) bool {
+	return existing.Record.Content == desired.Record.Content &&
+		existing.Record.Proxied == desired.Record.Proxied &&
+		(desired.Record.TTL <= 1 || existing.Record.TTL == desired.Record.TTL)
+}
+
+// The function returns the record owned by this instance among those sharing the zone, name and type
+// of record, or nil when there is none yet. A record is owned when it has the ID seen during a
+// previous update or carries the record comment. An untagged record is adopted when it is the only
+// one or already holds the desired content. Any other record is a sibling and is left untouched.
+func GetDNSRecord(ctx context.Context, record cf.ExtendedCloudflareDNSRecord, zoneID string) (*cf.ExtendedCloudflareDNSRecord, error) {
+	candidates, err := DNSProvider.GetDNSRecords(ctx, record, zoneID)
 	if err != nil {
 		return nil, err
 	}
 
+	if record.Record.ID != "" {
+		for i := range candidates {
+			if candidates[i].Record.ID == record.Record.ID {
+				return &candidates[i], nil
+			}
+		}
+	}
+
+	if record.Record.Comment != "" {
+		for i := range candidates {
+			if candidates[i].Record.Comment == record.Record.Comment {
+				return &candidates[i], nil
+			}
+		}
+	}
+
+	for i := range candidates {
+		if candidates[i].Record.Comment != "" {
+			continue
+		}
+		if len(candidates) == 1 || (record.Record.Content != "" && candidates[i].Record.Content == record.Record.Content) {
+			return &candidates[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// The function returns the managed record with the given zone, name and type. When several records
+// share them, the one tagged with Owner is preferred.
+func FindDNSRecord(zoneName, recordName, recordType string) *cf.ExtendedCloudflareDNSRecord {
+	record, ok := Records.Find(zoneName, recordName, recordType, Owner)
+	if !ok {
+		return nil
+	}
+	return &record
+}
+
+func DeleteRecord(ctx context.Context, recordName string, zoneName string, recordType string) (*cf.ExtendedCloudflareDNSRecord, error) {
+	var err error
+
 	record := &cf.ExtendedCloudflareDNSRecord{
 		Record: &dns.RecordResponse{
-			Name: recordName,
+			Name:    recordName,
+			Type:    dns.RecordResponseType(recordType),
+			Comment: Owner,
 		},
+		ZoneName: zoneName,
+	}
+
+	if err = record.Normalize(); err != nil {
+		return nil, fmt.Errorf("%w: %w", cf.ErrValidation, err)
+	}
+
+	if managed := FindDNSRecord(record.ZoneName, record.Record.Name, string(record.Record.Type)); managed != nil {
+		record.Record.ID = managed.Record.ID
+		record.Record.Comment = managed.Record.Comment
+	}
+
+	zoneID, err := DNSProvider.GetZoneID(ctx, record.ZoneName)
+	if err != nil {
+		return nil, err
 	}
 
-	record, err = CfAPI.GetDNSRecord(ctx, *record, zoneID)
+	found, err := GetDNSRecord(ctx, *record, zoneID)
 	if err != nil {
 		return nil, err
 	}
 
-	if record == nil || record.Record == nil || record.Record.ID == "" {
-		return nil, fmt.Errorf("record not found")
+	if found == nil {
+		return nil, fmt.Errorf("%w: %s", cf.ErrRecordNotFound, record.Record.Name)
+	}
+
+	if DryRun {
+		slog.With(PrepareRecordForLoggiong("record", found)).InfoContext(ctx, "Dry run, record not deleted")
+		return found, nil
 	}
 
-	response, err := CfAPI.DeleteDNSRecord(ctx, *record, zoneID)
+	err = DNSProvider.DeleteDNSRecord(ctx, *found, zoneID)
 	if err != nil {
 		return nil, err
 	}
 
-	slog.With("record", record).DebugContext(ctx, "Record deleted", "response", response)
+	slog.With("record", found).DebugContext(ctx, "Record deleted")
 
-	for i, r := range *Records {
-		if r.Record.Name == record.Record.Name {
-			*Records = (*Records)[:i]
-			*Records = append(*Records, (*Records)[i+1:]...)
-			break
-		}
-	}
-	return record, nil
+	Records.Remove(record.Key())
+	forgetRecord(ctx, *record)
+	forgetPin(ctx, record.Key())
+
+	return found, nil
 }
 
 func AddRecord(ctx context.Context, record *cf.ExtendedCloudflareDNSRecord) (*cf.ExtendedCloudflareDNSRecord, error) {
-	zoneID, err := CfAPI.GetZonesList(ctx, record.ZoneName)
+	if errs := append(record.Check(RecordValidation), CheckTemplate(*record)...); len(errs) > 0 {
+		return nil, &cf.ValidationError{Errors: errs}
+	}
+
+	if record.Record.Comment == "" {
+		record.Record.Comment = Owner
+	}
+
+	// Records not coming from the configuration are created through the REST API.
+	if record.Origin == "" {
+		record.Origin = cf.OriginAPI
+	}
+
+	zoneID, err := DNSProvider.GetZoneID(ctx, record.ZoneName)
 	if err != nil {
 		return nil, err
 	}
 
-	createParams := dns.RecordNewParams{
-		ZoneID: cloudflare.F(zoneID),
-		Body: dns.ARecordParam{
-			Name:    cloudflare.F(record.Record.Name),
-			Type:    cloudflare.F(dns.ARecordTypeA),
-			Content: cloudflare.F(ip.CurrentIp.IP),
-			TTL:     cloudflare.F(dns.TTL(record.Record.TTL)),
-			Proxied: cloudflare.F(record.Record.Proxied),
-		},
+	if record.Record.Content == "" {
+		if record.Record.Content, err = DesiredContent(*record); err != nil {
+			if errors.Is(err, ErrIPUnavailable) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: rendering template: %w", cf.ErrValidation, err)
+		}
 	}
 
-	response, err := CfAPI.CreateDNSRecord(ctx, createParams)
+	if DryRun {
+		slog.With(PrepareRecordForLoggiong("record", record)).InfoContext(ctx, "Dry run, record not created")
+		return record, nil
+	}
+
+	response, err := DNSProvider.CreateDNSRecord(ctx, *record, zoneID)
 	if err != nil {
 		return nil, err
 	}
 
 	slog.With("record", record).DebugContext(ctx, "Record create", "response", response)
 
-	*Records = append(*Records, *record)
+	// Providers without comments do not return one, keep the configured tag so the key stays stable.
+	response.Record.Comment = record.Record.Comment
+	response.Origin = record.Origin
+	Records.Upsert(*response)
+	persistRecord(ctx, *response)
+
 	return record, nil
 }
 
 func UpdateRecord(ctx context.Context, updatedRecord *cf.ExtendedCloudflareDNSRecord) (*cf.ExtendedCloudflareDNSRecord, error) {
-	zoneID, err := CfAPI.GetZonesList(ctx, updatedRecord.ZoneName)
+	if errs := append(updatedRecord.Check(RecordValidation), CheckTemplate(*updatedRecord)...); len(errs) > 0 {
+		return nil, &cf.ValidationError{Errors: errs}
+	}
+
+	if updatedRecord.Record.Comment == "" {
+		updatedRecord.Record.Comment = Owner
+	}
+
+	// A managed record keeps its origin, other records become managed through the REST API.
+	if updatedRecord.Origin == "" {
+		updatedRecord.Origin = cf.OriginAPI
+		if managed, ok := Records.Get(updatedRecord.Key()); ok && managed.Origin != "" {
+			updatedRecord.Origin = managed.Origin
+		}
+	}
+
+	zoneID, err := DNSProvider.GetZoneID(ctx, updatedRecord.ZoneName)
 	if err != nil {
 		return nil, err
 	}
 
-	record, err := CfAPI.GetDNSRecord(ctx, *updatedRecord, zoneID)
+	record, err := GetDNSRecord(ctx, *updatedRecord, zoneID)
 	if err != nil {
 		return nil, err
 	}
 
-	if record == nil || record.Record == nil || record.Record.ID == "" {
-		return nil, fmt.Errorf("record not found")
+	if record == nil {
+		return nil, fmt.Errorf("%w: %s", cf.ErrRecordNotFound, updatedRecord.Record.Name)
 	}
 
 	slog.With(PrepareRecordForLoggiong("record", record)).DebugContext(ctx, "Updating record", PrepareRecordForLoggiong("updatedRecord", updatedRecord))
 
 	record.ZoneName = updatedRecord.ZoneName
 
-	updateParams := dns.RecordUpdateParams{
-		ZoneID: cloudflare.F(zoneID),
-		Body: dns.ARecordParam{
-			Name:    cloudflare.F(updatedRecord.Record.Name),
-			Type:    cloudflare.F(dns.ARecordTypeA),
-			Content: cloudflare.F(ip.CurrentIp.IP),
-			TTL:     cloudflare.F(dns.TTL(updatedRecord.Record.TTL)),
-			Proxied: cloudflare.F(updatedRecord.Record.Proxied),
-		},
+	if updatedRecord.Record.Content == "" {
+		if updatedRecord.Record.Content, err = DesiredContent(*updatedRecord); err != nil {
+			if errors.Is(err, ErrIPUnavailable) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: rendering template: %w", cf.ErrValidation, err)
+		}
 	}
 
-	response, err := CfAPI.UpdateDNSRecord(ctx, record.Record.ID, updateParams)
+	if DryRun {
+		slog.With(PrepareRecordForLoggiong("record", updatedRecord)).InfoContext(ctx, "Dry run, record not updated")
+		return record, nil
+	}
+
+	// A failed record stays managed with its previous state, so the next cycles retry it.
+	response, err := DNSProvider.UpdateDNSRecord(ctx, record.Record.ID, *updatedRecord, zoneID)
 	if err != nil {
-		for i, r := range *Records {
-			if r.Record.Name == record.Record.Name {
-				records := *Records
-				*Records = append(records[:i], records[i+1:]...)
-				break
-			}
-		}
 		return nil, err
 	}
 
-	for i, r := range *Records {
-		if r.Record.Name == record.Record.Name {
-			(*Records)[i] = cf.ExtendedCloudflareDNSRecord{
-				Record:   response,
-				ZoneName: record.ZoneName,
-				CNAME:    record.CNAME,
-			}
-			break
-		}
-	}
+	response.Record.Comment = updatedRecord.Record.Comment
+	response.Origin = updatedRecord.Origin
+	Records.Upsert(*response)
+	persistRecord(ctx, *response)
 
 	return record, nil
 }
 
+// persistRecord saves a record created through the REST API to the storage. A failure is logged only,
+// as the change has already been applied by the provider.
+func persistRecord(ctx context.Context, record cf.ExtendedCloudflareDNSRecord) {
+	if Storage == nil || record.Origin != cf.OriginAPI {
+		return
+	}
+
+	if err := Storage.Put(record); err != nil {
+		slog.With(PrepareRecordForLoggiong("record", &record)).ErrorContext(ctx, "Error persisting record", "error", err)
+	}
+}
+
+// forgetRecord removes a deleted record from the storage.
+func forgetRecord(ctx context.Context, record cf.ExtendedCloudflareDNSRecord) {
+	if Storage == nil {
+		return
+	}
+
+	if err := Storage.Delete(record.Key()); err != nil {
+		slog.With(PrepareRecordForLoggiong("record", &record)).ErrorContext(ctx, "Error removing persisted record", "error", err)
+	}
+}
+
 func PrepareRecordForLoggiong(name string, record *cf.ExtendedCloudflareDNSRecord) slog.Attr {
 	return slog.Group(name,
 		slog.String("name", record.Record.Name),
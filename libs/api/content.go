@@ -0,0 +1,141 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/wasilak/cloudflare-ddns/libs/cf"
+	"github.com/wasilak/cloudflare-ddns/libs/ip"
+)
+
+// ErrIPUnavailable is returned when the content of a record needs the external IP address and it is not
+// known.
+var ErrIPUnavailable = errors.New("external IP address unavailable")
+
+// ContentData holds the values available to record content templates, e.g.
+// `v=spf1 ip4:{{.IPv4}} -all` or `heartbeat {{.Timestamp.Unix}}`.
+type ContentData struct {
+	// IP is the current external IP address.
+	IP string
+	// IPv4 and IPv6 hold the current address when it belongs to the family, and are empty otherwise.
+	IPv4 string
+	IPv6 string
+	// PreviousIP is the address detected before the latest change, empty until it changes.
+	PreviousIP string
+	Hostname   string
+	Timestamp  time.Time
+	// Name and ZoneName are the normalized name of the record and its zone.
+	Name     string
+	ZoneName string
+}
+
+// The function returns the template data for the record from the current state.
+func NewContentData(record cf.ExtendedCloudflareDNSRecord) ContentData {
+	hostname, _ := os.Hostname()
+
+	data := ContentData{
+		Hostname:  hostname,
+		Timestamp: time.Now().UTC(),
+		ZoneName:  record.ZoneName,
+	}
+
+	if record.Record != nil {
+		data.Name = record.Record.Name
+	}
+
+	if current := ip.Current(); current != nil {
+		data.IP = current.IP
+		if parsed := net.ParseIP(data.IP); parsed != nil {
+			if parsed.To4() != nil {
+				data.IPv4 = data.IP
+			} else {
+				data.IPv6 = data.IP
+			}
+		}
+	}
+
+	if previous := ip.Previous(); previous != nil {
+		data.PreviousIP = previous.IP
+	}
+
+	return data
+}
+
+// The function renders the content template of the record with the given data. Unknown fields and
+// functions are errors, and so is an empty result.
+func RenderContent(record cf.ExtendedCloudflareDNSRecord, data ContentData) (string, error) {
+	t, err := template.New("content").Option("missingkey=error").Parse(record.Template)
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := t.Execute(buf, data); err != nil {
+		return "", err
+	}
+
+	content := strings.TrimSpace(buf.String())
+	if content == "" {
+		return "", fmt.Errorf("template renders to empty content")
+	}
+
+	return content, nil
+}
+
+// The function checks that the content template of the record, if any, parses and renders with
+// sample data, so mistakes are reported when records are loaded rather than at update time.
+func CheckTemplate(record cf.ExtendedCloudflareDNSRecord) []cf.RecordError {
+	if record.Template == "" {
+		return nil
+	}
+
+	sample := ContentData{
+		IP:         "192.0.2.1",
+		IPv4:       "192.0.2.1",
+		IPv6:       "2001:db8::1",
+		PreviousIP: "192.0.2.2",
+		Hostname:   "localhost",
+		Timestamp:  time.Now().UTC(),
+		ZoneName:   record.ZoneName,
+	}
+	if record.Record != nil {
+		sample.Name = record.Record.Name
+	}
+
+	if _, err := RenderContent(record, sample); err != nil {
+		recordError := cf.RecordError{ZoneName: record.ZoneName, Field: "template", Message: err.Error()}
+		if record.Record != nil {
+			recordError.Name = record.Record.Name
+		}
+		return []cf.RecordError{recordError}
+	}
+
+	return nil
+}
+
+// The function returns the content the record should have: the content pinned by a DynDNS update,
+// the rendered template when set, the CNAME value for CNAME records and the current IP otherwise.
+func DesiredContent(record cf.ExtendedCloudflareDNSRecord) (string, error) {
+	if pinned, ok := PinnedContent(record.Key()); ok {
+		return pinned, nil
+	}
+
+	current := ip.Current()
+
+	switch {
+	case record.Template != "":
+		return RenderContent(record, NewContentData(record))
+	case record.Record.Type == "CNAME":
+		return record.CNAME, nil
+	case current == nil:
+		return "", fmt.Errorf("%w: not detected yet", ErrIPUnavailable)
+	default:
+		return current.IP, nil
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/wasilak/cloudflare-ddns/libs/cf"
+	"github.com/wasilak/cloudflare-ddns/libs/ip"
+)
+
+// CfAPI is the Cloudflare client used for Cloudflare specific targets such as IP Lists, regardless
+// of the DNS provider in use.
+var CfAPI *cf.CF
+var IPLists = &[]cf.IPList{}
+
+// The function makes sure the entry tagged for this instance in the IP List holds the current IP, and
+// returns what was done.
+func RunIPListUpdate(ctx context.Context, list cf.IPList) (IPListResult, error) {
+	started := time.Now()
+	current := ip.Current().IP
+	result := IPListResult{List: list, NewContent: current}
+
+	listID, err := CfAPI.GetIPListID(ctx, list)
+	if err != nil {
+		result.finish(started, err)
+		return result, err
+	}
+
+	items, err := CfAPI.GetIPListItems(ctx, list, listID)
+	if err != nil {
+		result.finish(started, err)
+		return result, err
+	}
+
+	for _, item := range items {
+		result.OldContent = append(result.OldContent, item.IP)
+	}
+
+	if len(items) == 1 && items[0].IP == current {
+		slog.With(PrepareIPListForLogging("ipList", &list)).DebugContext(ctx, "IP List entry up to date")
+		result.Action = ActionUnchanged
+		result.finish(started, nil)
+		return result, nil
+	}
+
+	result.Action = ActionUpdated
+	if len(items) == 0 {
+		result.Action = ActionCreated
+	}
+
+	if DryRun {
+		slog.With(PrepareIPListForLogging("ipList", &list)).InfoContext(ctx, "Dry run, IP List not changed", "action", result.Action)
+		result.finish(started, nil)
+		return result, nil
+	}
+
+	err = CfAPI.ReplaceIPListItem(ctx, list, listID, current, items)
+	result.finish(started, err)
+
+	return result, err
+}
+
+func PrepareIPListForLogging(name string, list *cf.IPList) slog.Attr {
+	return slog.Group(name,
+		slog.String("accountID", list.AccountID),
+		slog.String("listName", list.ListName),
+		slog.String("listID", list.ListID),
+		slog.String("comment", list.Comment),
+	)
+}
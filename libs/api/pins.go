@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"sync"
+
+	"github.com/wasilak/cloudflare-ddns/libs/cf"
+)
+
+// pins holds the contents pushed by DynDNS clients, keyed by cf.ExtendedCloudflareDNSRecord.Key. A
+// pinned record keeps its content instead of following the detected IP until the pin is released.
+// Pins are managed by the server only: they are not part of the record definitions accepted from the
+// configuration or the REST API.
+var pins = struct {
+	sync.RWMutex
+	contents map[string]string
+}{contents: map[string]string{}}
+
+// The function returns the content the record with the given key is pinned to, if any.
+func PinnedContent(key string) (string, bool) {
+	pins.RLock()
+	defer pins.RUnlock()
+
+	content, ok := pins.contents[key]
+	return content, ok
+}
+
+// The function replaces the pins with the given ones, e.g. loaded from the storage at startup.
+func LoadPins(contents map[string]string) {
+	pins.Lock()
+	defer pins.Unlock()
+
+	pins.contents = maps.Clone(contents)
+	if pins.contents == nil {
+		pins.contents = map[string]string{}
+	}
+}
+
+// The function pins the managed record to content: the record is updated to it and keeps it instead
+// of following the detected IP until ReleasePin is called. The pin is set before the update, so a
+// concurrent refresh cycle does not revert it, and dropped again when the update fails.
+func PinRecord(ctx context.Context, record cf.ExtendedCloudflareDNSRecord, content string) (*cf.ExtendedCloudflareDNSRecord, error) {
+	record.Record.Content = content
+
+	if DryRun {
+		return UpdateRecord(ctx, &record)
+	}
+
+	key := record.Key()
+	previous, wasPinned := setPin(key, content, true)
+
+	updated, err := UpdateRecord(ctx, &record)
+	if err != nil {
+		setPin(key, previous, wasPinned)
+		return nil, err
+	}
+
+	persistPin(ctx, key, content, true)
+
+	return updated, nil
+}
+
+// The function releases the pin of the managed record, which follows the template, CNAME or detected
+// IP again and is updated accordingly. The pin is restored when the update fails. Releasing a record
+// that is not pinned does nothing.
+func ReleasePin(ctx context.Context, record cf.ExtendedCloudflareDNSRecord) error {
+	key := record.Key()
+	content, ok := PinnedContent(key)
+	if !ok {
+		return nil
+	}
+
+	if DryRun {
+		slog.With(PrepareRecordForLoggiong("record", &record)).InfoContext(ctx, "Dry run, pin not released")
+		return nil
+	}
+
+	setPin(key, "", false)
+
+	record.Record.Content = ""
+	if _, err := UpdateRecord(ctx, &record); err != nil {
+		setPin(key, content, true)
+		return err
+	}
+
+	persistPin(ctx, key, "", false)
+	slog.With(PrepareRecordForLoggiong("record", &record)).InfoContext(ctx, "Pin released", "pinned", content)
+
+	return nil
+}
+
+// The function drops the pin of a record that is no longer managed.
+func forgetPin(ctx context.Context, key string) {
+	if _, ok := PinnedContent(key); !ok {
+		return
+	}
+
+	setPin(key, "", false)
+	persistPin(ctx, key, "", false)
+}
+
+// The function sets the pin of the record with the given key to content, or removes it when pinned is
+// false, and returns the previous pin.
+func setPin(key, content string, pinned bool) (string, bool) {
+	pins.Lock()
+	defer pins.Unlock()
+
+	previous, wasPinned := pins.contents[key]
+	if pinned {
+		pins.contents[key] = content
+	} else {
+		delete(pins.contents, key)
+	}
+
+	return previous, wasPinned
+}
+
+// persistPin saves the pin of a record to the storage, or removes it when pinned is false. A failure
+// is logged only, as the change has already been applied by the provider.
+func persistPin(ctx context.Context, key, content string, pinned bool) {
+	if Storage == nil {
+		return
+	}
+
+	var err error
+	if pinned {
+		err = Storage.PutPin(key, content)
+	} else {
+		err = Storage.DeletePin(key)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error persisting pin", "key", key, "error", err)
+	}
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"context"
+
+	"github.com/wasilak/cloudflare-ddns/libs/cf"
+	"github.com/wasilak/cloudflare-ddns/libs/rfc2136"
+)
+
+// Provider is implemented by DNS backends able to manage records on behalf of the daemon, the REST
+// API and notifications. Records are exchanged as cf.ExtendedCloudflareDNSRecord, backends other than
+// Cloudflare only use the fields they understand.
+type Provider interface {
+	// GetZoneID resolves a zone name to the identifier used by the other methods.
+	GetZoneID(ctx context.Context, zoneName string) (string, error)
+	// ListZones returns the names of the zones the backend can manage.
+	ListZones(ctx context.Context) ([]string, error)
+	ListDNSRecords(ctx context.Context, zoneID string) ([]cf.ExtendedCloudflareDNSRecord, error)
+	// GetDNSRecords returns every record sharing the zone, name and type of the given one.
+	GetDNSRecords(ctx context.Context, record cf.ExtendedCloudflareDNSRecord, zoneID string) ([]cf.ExtendedCloudflareDNSRecord, error)
+	CreateDNSRecord(ctx context.Context, record cf.ExtendedCloudflareDNSRecord, zoneID string) (*cf.ExtendedCloudflareDNSRecord, error)
+	// UpdateDNSRecord overwrites the existing record identified by recordID with the given one.
+	UpdateDNSRecord(ctx context.Context, recordID string, record cf.ExtendedCloudflareDNSRecord, zoneID string) (*cf.ExtendedCloudflareDNSRecord, error)
+	DeleteDNSRecord(ctx context.Context, record cf.ExtendedCloudflareDNSRecord, zoneID string) error
+}
+
+var (
+	_ Provider = (*cf.CF)(nil)
+	_ Provider = (*rfc2136.RFC2136)(nil)
+)
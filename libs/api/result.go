@@ -0,0 +1,145 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/wasilak/cloudflare-ddns/libs/cf"
+)
+
+// Actions taken for a record or an IP List during a run.
+const (
+	ActionCreated   = "created"
+	ActionUpdated   = "updated"
+	ActionUnchanged = "unchanged"
+	ActionSkipped   = "skipped"
+	ActionVetoed    = "vetoed"
+	ActionFailed    = "failed"
+)
+
+// RecordResult is the outcome of the update of a single record.
+type RecordResult struct {
+	Record     cf.ExtendedCloudflareDNSRecord `json:"record"`
+	Action     string                         `json:"action"`
+	OldContent string                         `json:"old_content,omitempty"`
+	NewContent string                         `json:"new_content,omitempty"`
+	Duration   time.Duration                  `json:"-"`
+	DurationMS int64                          `json:"duration_ms"`
+	Err        error                          `json:"-"`
+	Error      string                         `json:"error,omitempty"`
+}
+
+// IPListResult is the outcome of the update of a single IP List.
+type IPListResult struct {
+	List       cf.IPList     `json:"ip_list"`
+	Action     string        `json:"action"`
+	OldContent []string      `json:"old_content,omitempty"`
+	NewContent string        `json:"new_content,omitempty"`
+	Duration   time.Duration `json:"-"`
+	DurationMS int64         `json:"duration_ms"`
+	Err        error         `json:"-"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// RunResult is the outcome of a run over the records and IP Lists.
+type RunResult struct {
+	// DryRun tells the actions were only planned, see DryRun.
+	DryRun     bool           `json:"dry_run"`
+	StartedAt  time.Time      `json:"started_at"`
+	Duration   time.Duration  `json:"-"`
+	DurationMS int64          `json:"duration_ms"`
+	Records    []RecordResult `json:"records"`
+	IPLists    []IPListResult `json:"ip_lists"`
+}
+
+// The function records the error and duration of the result, marking it failed when err is not nil.
+func (r *RecordResult) finish(started time.Time, err error) {
+	r.Duration = time.Since(started)
+	r.DurationMS = r.Duration.Milliseconds()
+	if err != nil {
+		r.Action = ActionFailed
+		r.Err = err
+		r.Error = err.Error()
+	}
+}
+
+// The function records the error and duration of the result, marking it failed when err is not nil.
+func (r *IPListResult) finish(started time.Time, err error) {
+	r.Duration = time.Since(started)
+	r.DurationMS = r.Duration.Milliseconds()
+	if err != nil {
+		r.Action = ActionFailed
+		r.Err = err
+		r.Error = err.Error()
+	}
+}
+
+// The function returns the records whose update failed, so they can be retried.
+func (r RunResult) FailedRecords() []cf.ExtendedCloudflareDNSRecord {
+	failed := make([]cf.ExtendedCloudflareDNSRecord, 0)
+	for _, result := range r.Records {
+		if result.Err != nil {
+			failed = append(failed, result.Record)
+		}
+	}
+	return failed
+}
+
+// The function returns the IP Lists whose update failed, so they can be retried.
+func (r RunResult) FailedIPLists() []cf.IPList {
+	failed := make([]cf.IPList, 0)
+	for _, result := range r.IPLists {
+		if result.Err != nil {
+			failed = append(failed, result.List)
+		}
+	}
+	return failed
+}
+
+// The function returns the errors of the run joined together, or nil when everything succeeded.
+// Each error keeps its cause, so errors.Is still matches the cf errors.
+func (r RunResult) Err() error {
+	errs := make([]error, 0)
+
+	for _, result := range r.Records {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("record %s (%s): %w", result.Record.Record.Name, result.Record.Record.Type, result.Err))
+		}
+	}
+
+	for _, result := range r.IPLists {
+		if result.Err != nil {
+			name := result.List.ListName
+			if name == "" {
+				name = result.List.ListID
+			}
+			errs = append(errs, fmt.Errorf("ip list %s: %w", name, result.Err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+var lastRun struct {
+	mu     sync.RWMutex
+	result *RunResult
+}
+
+// The function stores the result of the latest run, exposed through the REST API.
+func SetLastRun(result RunResult) {
+	lastRun.mu.Lock()
+	defer lastRun.mu.Unlock()
+	lastRun.result = &result
+}
+
+// The function returns the result of the latest run, reporting false before the first one.
+func LastRun() (RunResult, bool) {
+	lastRun.mu.RLock()
+	defer lastRun.mu.RUnlock()
+	if lastRun.result == nil {
+		return RunResult{}, false
+	}
+	return *lastRun.result, true
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"sync"
+
+	"github.com/wasilak/cloudflare-ddns/libs/cf"
+)
+
+// RecordStore holds the managed records, shared between the runner, the daemon loop and the HTTP
+// handlers. Records are keyed by cf.ExtendedCloudflareDNSRecord.Key and kept in insertion order.
+// Reads return copies, so callers can never modify the stored records without going through the
+// store, and every change bumps the version.
+type RecordStore struct {
+	mu      sync.RWMutex
+	keys    []string
+	records map[string]cf.ExtendedCloudflareDNSRecord
+	version uint64
+}
+
+// The function returns a new store holding the given records.
+func NewRecordStore(records []cf.ExtendedCloudflareDNSRecord) *RecordStore {
+	s := &RecordStore{}
+	s.Replace(records)
+	return s
+}
+
+// The function replaces the content of the store with the given records. Records sharing a key are
+// collapsed into the last one.
+func (s *RecordStore) Replace(records []cf.ExtendedCloudflareDNSRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = make([]string, 0, len(records))
+	s.records = make(map[string]cf.ExtendedCloudflareDNSRecord, len(records))
+
+	for _, record := range records {
+		s.upsert(copyRecord(record))
+	}
+
+	s.version++
+}
+
+// The function returns a copy of every record in the store, in insertion order.
+func (s *RecordStore) Snapshot() []cf.ExtendedCloudflareDNSRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	records := make([]cf.ExtendedCloudflareDNSRecord, 0, len(s.keys))
+	for _, key := range s.keys {
+		records = append(records, copyRecord(s.records[key]))
+	}
+
+	return records
+}
+
+// The function returns a copy of the record with the given key.
+func (s *RecordStore) Get(key string) (cf.ExtendedCloudflareDNSRecord, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.records[key]
+	if !ok {
+		return cf.ExtendedCloudflareDNSRecord{}, false
+	}
+
+	return copyRecord(record), true
+}
+
+// The function returns a copy of the record with the given zone, name and type. When several records
+// share them, the one tagged with preferredComment wins, otherwise the first one.
+func (s *RecordStore) Find(zoneName, recordName, recordType, preferredComment string) (cf.ExtendedCloudflareDNSRecord, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var found cf.ExtendedCloudflareDNSRecord
+	var ok bool
+
+	for _, key := range s.keys {
+		r := s.records[key]
+		if r.ZoneName != zoneName || r.Record.Name != recordName || string(r.Record.Type) != recordType {
+			continue
+		}
+		if !ok || r.Record.Comment == preferredComment {
+			found, ok = r, true
+		}
+	}
+
+	if !ok {
+		return found, false
+	}
+
+	return copyRecord(found), true
+}
+
+// The function stores a copy of the record, replacing the one with the same key if any.
+func (s *RecordStore) Upsert(record cf.ExtendedCloudflareDNSRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.upsert(copyRecord(record))
+	s.version++
+}
+
+// The function removes the record with the given key and reports whether it was present.
+func (s *RecordStore) Remove(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.records[key]; !ok {
+		return false
+	}
+
+	delete(s.records, key)
+	for i, k := range s.keys {
+		if k == key {
+			s.keys = append(s.keys[:i:i], s.keys[i+1:]...)
+			break
+		}
+	}
+
+	s.version++
+	return true
+}
+
+// The function returns the number of records in the store.
+func (s *RecordStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.keys)
+}
+
+// The function returns the version of the store, increased on every change.
+func (s *RecordStore) Version() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.version
+}
+
+func (s *RecordStore) upsert(record cf.ExtendedCloudflareDNSRecord) {
+	key := record.Key()
+	if _, ok := s.records[key]; !ok {
+		s.keys = append(s.keys, key)
+	}
+	s.records[key] = record
+}
+
+// copyRecord returns a copy of the record not sharing the underlying record response.
+func copyRecord(record cf.ExtendedCloudflareDNSRecord) cf.ExtendedCloudflareDNSRecord {
+	if record.Record != nil {
+		response := *record.Record
+		record.Record = &response
+	}
+	return record
+}
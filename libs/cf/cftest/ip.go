@@ -0,0 +1,54 @@
+package cftest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// IPTransport is an http.RoundTripper answering the requests of the IP detection services with the
+// address it holds, and sending the requests to local servers, such as the fake API, to Next. Install
+// it as http.DefaultTransport so runs detect the address without network access.
+type IPTransport struct {
+	Next http.RoundTripper
+
+	mu      sync.Mutex
+	address string
+}
+
+// NewIPTransport returns an IPTransport answering address, sending the other requests to
+// http.DefaultTransport.
+func NewIPTransport(address string) *IPTransport {
+	return &IPTransport{Next: http.DefaultTransport, address: address}
+}
+
+// SetAddress changes the address answered to the next detections.
+func (t *IPTransport) SetAddress(address string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.address = address
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *IPTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if host := r.URL.Hostname(); host == "127.0.0.1" || host == "::1" || host == "localhost" {
+		return t.Next.RoundTrip(r)
+	}
+
+	t.mu.Lock()
+	address := t.address
+	t.mu.Unlock()
+
+	// Each detection service reads its own field.
+	body := fmt.Sprintf(`{"ip":%q,"query":%q,"address":%q}`, address, address, address)
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    r,
+	}, nil
+}
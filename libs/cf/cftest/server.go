@@ -0,0 +1,849 @@
+// Package cftest provides an in-memory fake of the Cloudflare API built on httptest. It implements
+// the subset of endpoints used by cloudflare-ddns (zones list, DNS records CRUD and batch, account IP
+// Lists) and allows injecting errors and rate limiting, so oneoff and daemon runs can be exercised
+// without network access or credentials.
+package cftest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BasePath is the path prefix under which the fake server exposes the API, mirroring
+// https://api.cloudflare.com/client/v4/.
+const BasePath = "/client/v4/"
+
+// Zone is a zone known to the fake server.
+type Zone struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	AccountID string `json:"-"`
+}
+
+// Record is a DNS record stored by the fake server, serialized the same way Cloudflare does.
+type Record struct {
+	ID         string    `json:"id"`
+	ZoneID     string    `json:"zone_id"`
+	ZoneName   string    `json:"zone_name"`
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	Content    string    `json:"content"`
+	TTL        float64   `json:"ttl"`
+	Proxied    bool      `json:"proxied"`
+	Proxiable  bool      `json:"proxiable"`
+	Comment    string    `json:"comment"`
+	CreatedOn  time.Time `json:"created_on"`
+	ModifiedOn time.Time `json:"modified_on"`
+}
+
+// IPList is an account-level IP List known to the fake server.
+type IPList struct {
+	ID        string `json:"id"`
+	AccountID string `json:"-"`
+	Name      string `json:"name"`
+}
+
+// IPListItem is an entry of an IP List.
+type IPListItem struct {
+	ID         string `json:"id"`
+	IP         string `json:"ip"`
+	Comment    string `json:"comment"`
+	CreatedOn  string `json:"created_on"`
+	ModifiedOn string `json:"modified_on"`
+}
+
+// Failure describes an error the fake server returns instead of handling a request. Method and
+// PathPrefix select the requests it applies to (empty values match everything, the prefix is
+// relative to BasePath, e.g. "zones/"). Times is the number of requests it applies to, 0 meaning
+// every matching request.
+type Failure struct {
+	Method     string
+	PathPrefix string
+	Status     int
+	Code       int
+	Message    string
+	Times      int
+}
+
+// Server is a fake Cloudflare API server. Use BaseURL as the client base URL.
+type Server struct {
+	*httptest.Server
+
+	// APIKey, when set, is required in the X-Auth-Key header of every request.
+	APIKey string
+
+	mu       sync.Mutex
+	zones    []Zone
+	records  map[string][]Record
+	lists    []IPList
+	items    map[string][]IPListItem
+	failures []*Failure
+	requests []string
+	nextID   int
+}
+
+type apiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type envelope struct {
+	Success    bool        `json:"success"`
+	Errors     []apiError  `json:"errors"`
+	Messages   []apiError  `json:"messages"`
+	Result     any         `json:"result"`
+	ResultInfo *resultInfo `json:"result_info,omitempty"`
+}
+
+type resultInfo struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	Count      int `json:"count"`
+	TotalCount int `json:"total_count"`
+	TotalPages int `json:"total_pages"`
+}
+
+// NewServer starts and returns a new fake Cloudflare API server. The caller should call Close when
+// finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		records: map[string][]Record{},
+		items:   map[string][]IPListItem{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+BasePath+"zones", s.listZones)
+	mux.HandleFunc("GET "+BasePath+"zones/{zone_id}/dns_records", s.listRecords)
+	mux.HandleFunc("POST "+BasePath+"zones/{zone_id}/dns_records", s.createRecord)
+	mux.HandleFunc("POST "+BasePath+"zones/{zone_id}/dns_records/batch", s.batchRecords)
+	mux.HandleFunc("GET "+BasePath+"zones/{zone_id}/dns_records/{id}", s.getRecord)
+	mux.HandleFunc("PUT "+BasePath+"zones/{zone_id}/dns_records/{id}", s.updateRecord)
+	mux.HandleFunc("PATCH "+BasePath+"zones/{zone_id}/dns_records/{id}", s.editRecord)
+	mux.HandleFunc("DELETE "+BasePath+"zones/{zone_id}/dns_records/{id}", s.deleteRecord)
+	mux.HandleFunc("GET "+BasePath+"accounts/{account_id}/rules/lists", s.listIPLists)
+	mux.HandleFunc("GET "+BasePath+"accounts/{account_id}/rules/lists/{list_id}/{resource}", s.getIPListResource)
+	mux.HandleFunc("POST "+BasePath+"accounts/{account_id}/rules/lists/{list_id}/items", s.createIPListItems)
+	mux.HandleFunc("DELETE "+BasePath+"accounts/{account_id}/rules/lists/{list_id}/items", s.deleteIPListItems)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, 7003, "No route for that URI")
+	})
+
+	s.Server = httptest.NewServer(s.middleware(mux))
+
+	return s
+}
+
+// BaseURL returns the base URL to configure the Cloudflare client with.
+func (s *Server) BaseURL() string {
+	return s.URL + BasePath
+}
+
+// AddZone registers a zone and returns it with its generated ID.
+func (s *Server) AddZone(name string) Zone {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zone := Zone{ID: s.newID(), Name: name}
+	s.zones = append(s.zones, zone)
+
+	return zone
+}
+
+// AddRecord stores a record in the given zone, filling in its ID and timestamps.
+func (s *Server) AddRecord(zoneID string, record Record) Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record = s.prepareRecord(zoneID, record)
+	s.records[zoneID] = append(s.records[zoneID], record)
+
+	return record
+}
+
+// Records returns a copy of the records stored in the given zone.
+func (s *Server) Records(zoneID string) []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Record(nil), s.records[zoneID]...)
+}
+
+// AddIPList registers an IP List in the given account and returns it with its generated ID.
+func (s *Server) AddIPList(accountID, name string) IPList {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := IPList{ID: s.newID(), AccountID: accountID, Name: name}
+	s.lists = append(s.lists, list)
+
+	return list
+}
+
+// AddIPListItem stores an entry in the given IP List, filling in its ID and timestamps.
+func (s *Server) AddIPListItem(listID string, item IPListItem) IPListItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item = s.prepareIPListItem(item)
+	s.items[listID] = append(s.items[listID], item)
+
+	return item
+}
+
+// IPListItems returns a copy of the entries of the given IP List.
+func (s *Server) IPListItems(listID string) []IPListItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]IPListItem(nil), s.items[listID]...)
+}
+
+// Fail registers a failure to be returned for matching requests.
+func (s *Server) Fail(failure Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = append(s.failures, &failure)
+}
+
+// RateLimit makes the next times requests fail with 429 Too Many Requests.
+func (s *Server) RateLimit(times int) {
+	s.Fail(Failure{
+		Status:  http.StatusTooManyRequests,
+		Code:    10000,
+		Message: "Rate limited. Please wait and consider throttling your request speed",
+		Times:   times,
+	})
+}
+
+// Requests returns the "METHOD path" of every request received so far, in order.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.requests...)
+}
+
+// middleware records requests, checks credentials and applies registered failures.
+func (s *Server) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, BasePath)
+
+		s.mu.Lock()
+		s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+		if s.APIKey != "" && r.Header.Get("X-Auth-Key") != s.APIKey {
+			s.mu.Unlock()
+			writeError(w, http.StatusForbidden, 9103, "Unknown X-Auth-Key or X-Auth-Email")
+			return
+		}
+
+		for i, f := range s.failures {
+			if (f.Method != "" && f.Method != r.Method) || !strings.HasPrefix(path, f.PathPrefix) {
+				continue
+			}
+
+			if f.Times > 0 {
+				f.Times--
+				if f.Times == 0 {
+					s.failures = append(s.failures[:i], s.failures[i+1:]...)
+				}
+			}
+			s.mu.Unlock()
+
+			if f.Status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "0")
+			}
+			writeError(w, f.Status, f.Code, f.Message)
+			return
+		}
+		s.mu.Unlock()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) listZones(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := r.URL.Query().Get("name")
+	accountID := r.URL.Query().Get("account.id")
+
+	result := []map[string]any{}
+	for _, zone := range s.zones {
+		if name != "" && zone.Name != name {
+			continue
+		}
+		if accountID != "" && zone.AccountID != accountID {
+			continue
+		}
+		result = append(result, map[string]any{
+			"id":      zone.ID,
+			"name":    zone.Name,
+			"status":  "active",
+			"account": map[string]any{"id": zone.AccountID},
+		})
+	}
+
+	writePage(w, r, result)
+}
+
+func (s *Server) listRecords(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zoneID := r.PathValue("zone_id")
+	if !s.hasZone(zoneID) {
+		writeError(w, http.StatusNotFound, 7003, "Could not route to /zones/"+zoneID)
+		return
+	}
+
+	query := r.URL.Query()
+	name := firstNonEmpty(query.Get("name.exact"), query.Get("name"))
+	recordType := query.Get("type")
+	content := firstNonEmpty(query.Get("content.exact"), query.Get("content"))
+
+	result := []Record{}
+	for _, record := range s.records[zoneID] {
+		if name != "" && record.Name != name {
+			continue
+		}
+		if recordType != "" && record.Type != recordType {
+			continue
+		}
+		if content != "" && record.Content != content {
+			continue
+		}
+		result = append(result, record)
+	}
+
+	writePage(w, r, result)
+}
+
+func (s *Server) getRecord(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := s.findRecord(r.PathValue("zone_id"), r.PathValue("id"))
+	if index < 0 {
+		writeRecordNotFound(w)
+		return
+	}
+
+	writeResult(w, http.StatusOK, s.records[r.PathValue("zone_id")][index])
+}
+
+func (s *Server) createRecord(w http.ResponseWriter, r *http.Request) {
+	var record Record
+	if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+		writeError(w, http.StatusBadRequest, 9207, "Request body is invalid.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zoneID := r.PathValue("zone_id")
+	if !s.hasZone(zoneID) {
+		writeError(w, http.StatusNotFound, 7003, "Could not route to /zones/"+zoneID)
+		return
+	}
+
+	if code, message := s.validateRecord(zoneID, record, ""); code != 0 {
+		writeError(w, http.StatusBadRequest, code, message)
+		return
+	}
+
+	record = s.prepareRecord(zoneID, record)
+	s.records[zoneID] = append(s.records[zoneID], record)
+
+	writeResult(w, http.StatusOK, record)
+}
+
+func (s *Server) updateRecord(w http.ResponseWriter, r *http.Request) {
+	var record Record
+	if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+		writeError(w, http.StatusBadRequest, 9207, "Request body is invalid.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zoneID := r.PathValue("zone_id")
+	index := s.findRecord(zoneID, r.PathValue("id"))
+	if index < 0 {
+		writeRecordNotFound(w)
+		return
+	}
+
+	existing := s.records[zoneID][index]
+	if code, message := s.validateRecord(zoneID, record, existing.ID); code != 0 {
+		writeError(w, http.StatusBadRequest, code, message)
+		return
+	}
+
+	record.ID = existing.ID
+	record.ZoneID = existing.ZoneID
+	record.ZoneName = existing.ZoneName
+	record.CreatedOn = existing.CreatedOn
+	record.ModifiedOn = time.Now().UTC()
+	record.Proxiable = proxiable(record.Type)
+	s.records[zoneID][index] = record
+
+	writeResult(w, http.StatusOK, record)
+}
+
+func (s *Server) editRecord(w http.ResponseWriter, r *http.Request) {
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeError(w, http.StatusBadRequest, 9207, "Request body is invalid.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zoneID := r.PathValue("zone_id")
+	index := s.findRecord(zoneID, r.PathValue("id"))
+	if index < 0 {
+		writeRecordNotFound(w)
+		return
+	}
+
+	record, err := applyPatch(s.records[zoneID][index], patch)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 9207, "Request body is invalid.")
+		return
+	}
+	s.records[zoneID][index] = record
+
+	writeResult(w, http.StatusOK, record)
+}
+
+func (s *Server) deleteRecord(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zoneID := r.PathValue("zone_id")
+	index := s.findRecord(zoneID, r.PathValue("id"))
+	if index < 0 {
+		writeRecordNotFound(w)
+		return
+	}
+
+	id := s.records[zoneID][index].ID
+	s.records[zoneID] = append(s.records[zoneID][:index], s.records[zoneID][index+1:]...)
+
+	writeResult(w, http.StatusOK, map[string]string{"id": id})
+}
+
+// batchRecords executes deletes, patches, puts and posts in that order, as Cloudflare does. The
+// batch is applied atomically: if any operation fails, no change is made.
+func (s *Server) batchRecords(w http.ResponseWriter, r *http.Request) {
+	var batch struct {
+		Deletes []struct {
+			ID string `json:"id"`
+		} `json:"deletes"`
+		Patches []map[string]json.RawMessage `json:"patches"`
+		Puts    []Record                     `json:"puts"`
+		Posts   []Record                     `json:"posts"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		writeError(w, http.StatusBadRequest, 9207, "Request body is invalid.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zoneID := r.PathValue("zone_id")
+	if !s.hasZone(zoneID) {
+		writeError(w, http.StatusNotFound, 7003, "Could not route to /zones/"+zoneID)
+		return
+	}
+
+	original := s.records[zoneID]
+	s.records[zoneID] = append([]Record(nil), original...)
+
+	rollback := func(code int, message string) {
+		s.records[zoneID] = original
+		writeError(w, http.StatusBadRequest, code, message)
+	}
+
+	result := map[string][]Record{
+		"deletes": {},
+		"patches": {},
+		"puts":    {},
+		"posts":   {},
+	}
+
+	for _, d := range batch.Deletes {
+		index := s.findRecord(zoneID, d.ID)
+		if index < 0 {
+			rollback(81044, "Record does not exist.")
+			return
+		}
+		result["deletes"] = append(result["deletes"], s.records[zoneID][index])
+		s.records[zoneID] = append(s.records[zoneID][:index], s.records[zoneID][index+1:]...)
+	}
+
+	for _, patch := range batch.Patches {
+		var id string
+		json.Unmarshal(patch["id"], &id)
+
+		index := s.findRecord(zoneID, id)
+		if index < 0 {
+			rollback(81044, "Record does not exist.")
+			return
+		}
+
+		record, err := applyPatch(s.records[zoneID][index], patch)
+		if err != nil {
+			rollback(9207, "Request body is invalid.")
+			return
+		}
+		s.records[zoneID][index] = record
+		result["patches"] = append(result["patches"], record)
+	}
+
+	for _, put := range batch.Puts {
+		index := s.findRecord(zoneID, put.ID)
+		if index < 0 {
+			rollback(81044, "Record does not exist.")
+			return
+		}
+
+		existing := s.records[zoneID][index]
+		put.ZoneID = existing.ZoneID
+		put.ZoneName = existing.ZoneName
+		put.CreatedOn = existing.CreatedOn
+		put.ModifiedOn = time.Now().UTC()
+		put.Proxiable = proxiable(put.Type)
+		s.records[zoneID][index] = put
+		result["puts"] = append(result["puts"], put)
+	}
+
+	for _, post := range batch.Posts {
+		if code, message := s.validateRecord(zoneID, post, ""); code != 0 {
+			rollback(code, message)
+			return
+		}
+		post = s.prepareRecord(zoneID, post)
+		s.records[zoneID] = append(s.records[zoneID], post)
+		result["posts"] = append(result["posts"], post)
+	}
+
+	writeResult(w, http.StatusOK, result)
+}
+
+func (s *Server) listIPLists(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := []map[string]any{}
+	for _, list := range s.lists {
+		if list.AccountID != r.PathValue("account_id") {
+			continue
+		}
+		result = append(result, map[string]any{
+			"id":        list.ID,
+			"name":      list.Name,
+			"kind":      "ip",
+			"num_items": len(s.items[list.ID]),
+		})
+	}
+
+	writeResult(w, http.StatusOK, result)
+}
+
+// getIPListResource serves both the items of a list and the status of bulk operations, whose paths
+// cannot be told apart by the router.
+func (s *Server) getIPListResource(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("list_id") == "bulk_operations" {
+		// Operations are applied synchronously, so they are always reported as completed.
+		writeResult(w, http.StatusOK, map[string]string{
+			"id":        r.PathValue("resource"),
+			"status":    "completed",
+			"completed": time.Now().UTC().Format(time.RFC3339),
+		})
+		return
+	}
+
+	if r.PathValue("resource") != "items" {
+		writeError(w, http.StatusNotFound, 7003, "No route for that URI")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.hasIPList(r.PathValue("account_id"), r.PathValue("list_id")) {
+		writeError(w, http.StatusNotFound, 10000, "list not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"success":     true,
+		"errors":      []apiError{},
+		"messages":    []apiError{},
+		"result":      append([]IPListItem{}, s.items[r.PathValue("list_id")]...),
+		"result_info": map[string]any{"count": len(s.items[r.PathValue("list_id")])},
+	})
+}
+
+func (s *Server) createIPListItems(w http.ResponseWriter, r *http.Request) {
+	var items []IPListItem
+	if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+		writeError(w, http.StatusBadRequest, 9207, "Request body is invalid.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	listID := r.PathValue("list_id")
+	if !s.hasIPList(r.PathValue("account_id"), listID) {
+		writeError(w, http.StatusNotFound, 10000, "list not found")
+		return
+	}
+
+	for _, item := range items {
+		s.items[listID] = append(s.items[listID], s.prepareIPListItem(item))
+	}
+
+	writeResult(w, http.StatusOK, map[string]string{"operation_id": s.newID()})
+}
+
+func (s *Server) deleteIPListItems(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Items []struct {
+			ID string `json:"id"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, 9207, "Request body is invalid.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	listID := r.PathValue("list_id")
+	if !s.hasIPList(r.PathValue("account_id"), listID) {
+		writeError(w, http.StatusNotFound, 10000, "list not found")
+		return
+	}
+
+	for _, deleted := range body.Items {
+		for i, item := range s.items[listID] {
+			if item.ID == deleted.ID {
+				s.items[listID] = append(s.items[listID][:i], s.items[listID][i+1:]...)
+				break
+			}
+		}
+	}
+
+	writeResult(w, http.StatusOK, map[string]string{"operation_id": s.newID()})
+}
+
+func (s *Server) hasIPList(accountID, listID string) bool {
+	for _, list := range s.lists {
+		if list.ID == listID && list.AccountID == accountID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) prepareIPListItem(item IPListItem) IPListItem {
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	item.ID = s.newID()
+	item.CreatedOn = now
+	item.ModifiedOn = now
+
+	return item
+}
+
+// validateRecord applies the few server-side checks the tool relies on, such as refusing to create
+// a record identical to an existing one or mixing CNAME with other records on the same name.
+func (s *Server) validateRecord(zoneID string, record Record, skipID string) (int, string) {
+	if record.Name == "" || record.Type == "" {
+		return 9000, "DNS name is invalid."
+	}
+
+	for _, existing := range s.records[zoneID] {
+		if existing.ID == skipID || existing.Name != record.Name {
+			continue
+		}
+		if existing.Type == record.Type && existing.Content == record.Content {
+			return 81058, "An identical record already exists."
+		}
+		if (existing.Type == "CNAME") != (record.Type == "CNAME") {
+			return 81053, "An A, AAAA, or CNAME record with that host already exists."
+		}
+	}
+
+	return 0, ""
+}
+
+func (s *Server) prepareRecord(zoneID string, record Record) Record {
+	now := time.Now().UTC()
+
+	record.ID = s.newID()
+	record.ZoneID = zoneID
+	record.ZoneName = s.zoneName(zoneID)
+	record.Proxiable = proxiable(record.Type)
+	record.CreatedOn = now
+	record.ModifiedOn = now
+
+	if record.TTL == 0 {
+		record.TTL = 1
+	}
+
+	return record
+}
+
+func (s *Server) newID() string {
+	s.nextID++
+	return fmt.Sprintf("%032x", s.nextID)
+}
+
+func (s *Server) hasZone(zoneID string) bool {
+	return s.zoneName(zoneID) != ""
+}
+
+func (s *Server) zoneName(zoneID string) string {
+	for _, zone := range s.zones {
+		if zone.ID == zoneID {
+			return zone.Name
+		}
+	}
+	return ""
+}
+
+func (s *Server) findRecord(zoneID, id string) int {
+	for i, record := range s.records[zoneID] {
+		if record.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func applyPatch(record Record, patch map[string]json.RawMessage) (Record, error) {
+	current, err := json.Marshal(record)
+	if err != nil {
+		return record, err
+	}
+
+	var merged map[string]json.RawMessage
+	if err := json.Unmarshal(current, &merged); err != nil {
+		return record, err
+	}
+
+	for key, value := range patch {
+		switch key {
+		case "id", "zone_id", "zone_name", "created_on":
+			continue
+		}
+		merged[key] = value
+	}
+
+	body, err := json.Marshal(merged)
+	if err != nil {
+		return record, err
+	}
+
+	var patched Record
+	if err := json.Unmarshal(body, &patched); err != nil {
+		return record, err
+	}
+
+	patched.ModifiedOn = time.Now().UTC()
+	patched.Proxiable = proxiable(patched.Type)
+
+	return patched, nil
+}
+
+func proxiable(recordType string) bool {
+	switch recordType {
+	case "A", "AAAA", "CNAME":
+		return true
+	}
+	return false
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// writePage writes a paginated list response honouring the page and per_page query parameters.
+func writePage[T any](w http.ResponseWriter, r *http.Request, items []T) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if perPage < 1 {
+		perPage = 100
+	}
+
+	start := min((page-1)*perPage, len(items))
+	end := min(start+perPage, len(items))
+
+	writeJSON(w, http.StatusOK, envelope{
+		Success:  true,
+		Errors:   []apiError{},
+		Messages: []apiError{},
+		Result:   items[start:end],
+		ResultInfo: &resultInfo{
+			Page:       page,
+			PerPage:    perPage,
+			Count:      end - start,
+			TotalCount: len(items),
+			TotalPages: (len(items) + perPage - 1) / perPage,
+		},
+	})
+}
+
+func writeResult(w http.ResponseWriter, status int, result any) {
+	writeJSON(w, status, envelope{
+		Success:  true,
+		Errors:   []apiError{},
+		Messages: []apiError{},
+		Result:   result,
+	})
+}
+
+func writeRecordNotFound(w http.ResponseWriter) {
+	writeError(w, http.StatusNotFound, 81044, "Record does not exist.")
+}
+
+func writeError(w http.ResponseWriter, status, code int, message string) {
+	writeJSON(w, status, envelope{
+		Success:  false,
+		Errors:   []apiError{{Code: code, Message: message}},
+		Messages: []apiError{},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -15,136 +15,221 @@ type CF struct {
 	Client *cloudflare.Client
 }
 
+// Record origins, telling where a managed record was declared.
+const (
+	// OriginConfig marks records declared in the config file or the environment.
+	OriginConfig = "config"
+	// OriginAPI marks records created through the REST API, kept in the persistent storage.
+	OriginAPI = "api"
+)
+
 type ExtendedCloudflareDNSRecord struct {
 	Record   *dns.RecordResponse `mapstructure:"record" json:"record" yaml:"record"`
 	CNAME    string              `mapstructure:"CNAME,omitempty" json:"CNAME,omitempty" yaml:"CNAME,omitempty"`
 	ZoneName string              `mapstructure:"zone_name" json:"zone_name" yaml:"zone_name"`
+	// Template, when set, is a Go template rendered into the record content on every update, see
+	// api.ContentData for the available fields.
+	Template string `mapstructure:"template,omitempty" json:"template,omitempty" yaml:"template,omitempty"`
+	Origin   string `mapstructure:"-" json:"origin,omitempty" yaml:"-"`
+}
+
+// The function returns the key identifying the record among the managed ones: zone, name, type and
+// comment, the latter telling apart several records sharing a name (e.g. round-robin A records).
+func (r ExtendedCloudflareDNSRecord) Key() string {
+	return r.ZoneName + "/" + r.Record.Name + "/" + string(r.Record.Type) + "/" + r.Record.Comment
 }
 
 // The function initializes a Cloudflare API client with the provided API key, email, and context.
-func (cf *CF) Init(CFAPIKey, CFAPIEmail string) {
-	cf.Client = cloudflare.NewClient(
+// When baseURL is not empty, the client talks to it instead of the public Cloudflare API, which
+// allows pointing the tool at a proxy or at the fake server from the cftest package.
+func (cf *CF) Init(CFAPIKey, CFAPIEmail, baseURL string) {
+	opts := []option.RequestOption{
 		option.WithAPIKey(CFAPIKey),     // defaults to os.LookupEnv("CLOUDFLARE_API_KEY")
 		option.WithAPIEmail(CFAPIEmail), // defaults to os.LookupEnv("CLOUDFLARE_EMAIL")
-	)
+	}
+
+	if baseURL != "" {
+		opts = append(opts, option.WithBaseURL(baseURL))
+	}
+
+	cf.Client = cloudflare.NewClient(opts...)
 }
 
-func (cf *CF) GetZonesList(ctx context.Context, zoneName string) (string, error) {
+// The function returns the ID of the zone with the given name.
+func (cf *CF) GetZoneID(ctx context.Context, zoneName string) (string, error) {
 	zones, err := cf.Client.Zones.List(ctx, zones.ZoneListParams{
 		Name: cloudflare.F(zoneName),
 	})
 	if err != nil {
 		slog.With("zoneName", zoneName).ErrorContext(ctx, "Error GetZoneID", "error", err)
-		return "", err
+		return "", wrapAPIError(err, nil)
 	}
 
 	if len(zones.Result) == 0 {
 		slog.With("zoneName", zoneName).ErrorContext(ctx, "Zone not found")
-		return "", fmt.Errorf("zone not found")
+		return "", fmt.Errorf("%w: %s", ErrZoneNotFound, zoneName)
 	}
 
 	return zones.Result[0].ID, nil
 }
 
-// This function retrieves a DNS record from Cloudflare using its name.
-func (cf *CF) ListDNSRecords(ctx context.Context, zoneID string) ([]ExtendedCloudflareDNSRecord, error) {
-	result, err := cf.Client.DNS.Records.List(ctx, dns.RecordListParams{
-		ZoneID: cloudflare.F(zoneID),
-	})
-	if err != nil {
-		return nil, err
+// This function returns the names of all zones the credentials have access to.
+func (cf *CF) ListZones(ctx context.Context) ([]string, error) {
+	names := make([]string, 0)
+
+	iter := cf.Client.Zones.ListAutoPaging(ctx, zones.ZoneListParams{})
+	for iter.Next() {
+		names = append(names, iter.Current().Name)
 	}
+	if err := iter.Err(); err != nil {
+		slog.ErrorContext(ctx, "Error ListZones", "error", err)
+		return nil, wrapAPIError(err, nil)
+	}
+
+	return names, nil
+}
 
+// This function returns every DNS record of the zone, fetching all the pages.
+func (cf *CF) ListDNSRecords(ctx context.Context, zoneID string) ([]ExtendedCloudflareDNSRecord, error) {
 	records := make([]ExtendedCloudflareDNSRecord, 0)
 
-	for _, item := range result.Result {
+	iter := cf.Client.DNS.Records.ListAutoPaging(ctx, dns.RecordListParams{
+		ZoneID: cloudflare.F(zoneID),
+	})
+	for iter.Next() {
+		item := iter.Current()
 		records = append(records, ExtendedCloudflareDNSRecord{
 			Record: &item,
 		})
 	}
+	if err := iter.Err(); err != nil {
+		return nil, wrapAPIError(err, ErrZoneNotFound)
+	}
 
 	return records, nil
 }
 
-// This function retrieves a DNS record from Cloudflare using its name.
-func (cf *CF) GetDNSRecord(ctx context.Context, record ExtendedCloudflareDNSRecord, zoneID string) (*ExtendedCloudflareDNSRecord, error) {
+// This function retrieves the DNS records from Cloudflare sharing the normalized name and type of
+// the given record.
+func (cf *CF) GetDNSRecords(ctx context.Context, record ExtendedCloudflareDNSRecord, zoneID string) ([]ExtendedCloudflareDNSRecord, error) {
+	lookup := *record.Record
+	record.Record = &lookup
+	if err := record.Normalize(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrValidation, err)
+	}
+
 	result, err := cf.Client.DNS.Records.List(ctx, dns.RecordListParams{
 		ZoneID: cloudflare.F(zoneID),
 		Name:   cloudflare.F(dns.RecordListParamsName{Exact: cloudflare.F(record.Record.Name)}),
+		Type:   cloudflare.F(dns.RecordListParamsType(record.Record.Type)),
 	})
 	if err != nil {
-		return nil, err
+		return nil, wrapAPIError(err, ErrZoneNotFound)
 	}
 
-	var recordGet *dns.RecordResponse
+	records := make([]ExtendedCloudflareDNSRecord, 0)
+
 	for _, item := range result.Result {
-		if item.Name == record.Record.Name {
-			recordGet = &item
-			break
+		if item.Name == record.Record.Name && item.Type == record.Record.Type {
+			records = append(records, ExtendedCloudflareDNSRecord{
+				Record:   &item,
+				ZoneName: record.ZoneName,
+				CNAME:    record.CNAME,
+				Template: record.Template,
+				Origin:   record.Origin,
+			})
 		}
 	}
 
-	convertedRecord := ExtendedCloudflareDNSRecord{
-		Record:   recordGet,
-		ZoneName: record.ZoneName,
-		CNAME:    record.CNAME,
-	}
-
-	return &convertedRecord, nil
+	return records, nil
 }
 
 // The function creates a DNS record and logs its details.
-func (cf *CF) CreateDNSRecord(ctx context.Context, params dns.RecordNewParams) (*dns.RecordResponse, error) {
+func (cf *CF) CreateDNSRecord(ctx context.Context, record ExtendedCloudflareDNSRecord, zoneID string) (*ExtendedCloudflareDNSRecord, error) {
+	params := dns.RecordNewParams{
+		ZoneID: cloudflare.F(zoneID),
+		Body: dns.RecordNewParamsBody{
+			Name:    cloudflare.F(record.Record.Name),
+			Type:    cloudflare.F(dns.RecordNewParamsBodyType(record.Record.Type)),
+			Content: cloudflare.F(record.Record.Content),
+			TTL:     cloudflare.F(record.Record.TTL),
+			Proxied: cloudflare.F(record.Record.Proxied),
+			Comment: cloudflare.F(record.Record.Comment),
+		},
+	}
 
-	record, err := cf.Client.DNS.Records.New(ctx, params)
+	created, err := cf.Client.DNS.Records.New(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, wrapAPIError(err, ErrZoneNotFound)
 	}
 
 	slog.With("params", params).InfoContext(ctx, "Record created",
-		slog.String("Name", record.Name),
-		slog.String("Content", record.Content),
-		slog.Bool("Proxied", record.Proxied),
-		slog.Int("TTL", int(record.TTL)),
-		slog.String("CreatedOn", record.CreatedOn.String()),
-		slog.String("ModifiedOn", record.ModifiedOn.String()),
+		slog.String("Name", created.Name),
+		slog.String("Content", created.Content),
+		slog.Bool("Proxied", created.Proxied),
+		slog.Int("TTL", int(created.TTL)),
+		slog.String("CreatedOn", created.CreatedOn.String()),
+		slog.String("ModifiedOn", created.ModifiedOn.String()),
 		slog.Bool("Updated", false),
 		slog.Bool("Created", true),
 	)
 
-	return record, nil
+	return &ExtendedCloudflareDNSRecord{
+		Record:   created,
+		ZoneName: record.ZoneName,
+		CNAME:    record.CNAME,
+		Template: record.Template,
+		Origin:   record.Origin,
+	}, nil
 }
 
 // This function updates a DNS record and logs the changes.
-func (cf *CF) UpdateDNSRecord(ctx context.Context, recordId string, params dns.RecordUpdateParams) (*dns.RecordResponse, error) {
+func (cf *CF) UpdateDNSRecord(ctx context.Context, recordID string, record ExtendedCloudflareDNSRecord, zoneID string) (*ExtendedCloudflareDNSRecord, error) {
+	params := dns.RecordUpdateParams{
+		ZoneID: cloudflare.F(zoneID),
+		Body: dns.RecordUpdateParamsBody{
+			Name:    cloudflare.F(record.Record.Name),
+			Type:    cloudflare.F(dns.RecordUpdateParamsBodyType(record.Record.Type)),
+			Content: cloudflare.F(record.Record.Content),
+			TTL:     cloudflare.F(record.Record.TTL),
+			Proxied: cloudflare.F(record.Record.Proxied),
+			Comment: cloudflare.F(record.Record.Comment),
+		},
+	}
 
-	record, err := cf.Client.DNS.Records.Update(ctx, recordId, params)
+	updated, err := cf.Client.DNS.Records.Update(ctx, recordID, params)
 	if err != nil {
 		slog.With("params", params).ErrorContext(ctx, "UpdateDNSRecord error", "err", err)
-		return nil, err
+		return nil, wrapAPIError(err, ErrRecordNotFound)
 	}
 
 	slog.InfoContext(ctx, "Record updated",
-		slog.String("Name", record.Name),
-		slog.String("Content", record.Content),
-		slog.Bool("Proxied", record.Proxied),
-		slog.Int("TTL", int(record.TTL)),
+		slog.String("Name", updated.Name),
+		slog.String("Content", updated.Content),
+		slog.Bool("Proxied", updated.Proxied),
+		slog.Int("TTL", int(updated.TTL)),
 		slog.Bool("Updated", true),
 		slog.Bool("Created", false),
 	)
 
-	return record, nil
+	return &ExtendedCloudflareDNSRecord{
+		Record:   updated,
+		ZoneName: record.ZoneName,
+		CNAME:    record.CNAME,
+		Template: record.Template,
+		Origin:   record.Origin,
+	}, nil
 }
 
 // This function deletes a DNS record and logs the changes.
-func (cf *CF) DeleteDNSRecord(ctx context.Context, record ExtendedCloudflareDNSRecord, zoneID string) (*dns.RecordDeleteResponse, error) {
+func (cf *CF) DeleteDNSRecord(ctx context.Context, record ExtendedCloudflareDNSRecord, zoneID string) error {
 
-	response, err := cf.Client.DNS.Records.Delete(ctx, record.Record.ID, dns.RecordDeleteParams{
+	_, err := cf.Client.DNS.Records.Delete(ctx, record.Record.ID, dns.RecordDeleteParams{
 		ZoneID: cloudflare.F(zoneID),
 	})
 	if err != nil {
 		slog.With("record", record).ErrorContext(ctx, "DeleteDNSRecord error", "msg", err)
-		return response, err
+		return wrapAPIError(err, ErrRecordNotFound)
 	}
 
 	slog.InfoContext(ctx, "Record deleted",
@@ -153,5 +238,5 @@ func (cf *CF) DeleteDNSRecord(ctx context.Context, record ExtendedCloudflareDNSR
 		slog.Bool("Proxied", record.Record.Proxied),
 		slog.Int("TTL", int(record.Record.TTL)),
 	)
-	return response, nil
+	return nil
 }
@@ -0,0 +1,164 @@
+package cf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	cloudflare "github.com/cloudflare/cloudflare-go/v4"
+	"github.com/wasilak/cloudflare-ddns/libs/cf/cftest"
+)
+
+// The function returns a client of a fake Cloudflare API holding the example.com zone.
+func newTestCF(t *testing.T) (*CF, *cftest.Server) {
+	t.Helper()
+
+	fake := cftest.NewServer()
+	t.Cleanup(fake.Close)
+	fake.AddZone("example.com")
+
+	client := &CF{}
+	client.Init("key", "user@example.com", fake.BaseURL())
+
+	return client, fake
+}
+
+func TestGetZoneID(t *testing.T) {
+	client, _ := newTestCF(t)
+	ctx := context.Background()
+
+	if zoneID, err := client.GetZoneID(ctx, "example.com"); err != nil || zoneID == "" {
+		t.Errorf("GetZoneID(example.com): %q, %v", zoneID, err)
+	}
+	if _, err := client.GetZoneID(ctx, "example.org"); err == nil {
+		t.Error("GetZoneID(example.org) found an unknown zone")
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		failure cftest.Failure
+		want    error
+	}{
+		{
+			name:    "rejected credentials",
+			failure: cftest.Failure{Status: http.StatusForbidden, Code: 9109, Message: "Unauthorized to access requested resource"},
+			want:    ErrUnauthorized,
+		},
+		{
+			name:    "rate limited",
+			failure: cftest.Failure{Status: http.StatusTooManyRequests, Code: 10000, Message: "Rate limited."},
+			want:    ErrRateLimited,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, fake := newTestCF(t)
+			fake.Fail(tt.failure)
+
+			_, err := client.GetZoneID(context.Background(), "example.com")
+			if !errors.Is(err, tt.want) {
+				t.Errorf("GetZoneID: %v, want an error wrapping %v", err, tt.want)
+			}
+
+			var apiError *cloudflare.Error
+			if !errors.As(err, &apiError) {
+				t.Fatalf("GetZoneID: %v, want a *cloudflare.Error", err)
+			}
+			if apiError.StatusCode != tt.failure.Status {
+				t.Errorf("status %d, want %d", apiError.StatusCode, tt.failure.Status)
+			}
+		})
+	}
+}
+
+func TestGetIPListID(t *testing.T) {
+	client, fake := newTestCF(t)
+	fake.AddIPList("account", "office")
+	home := fake.AddIPList("account", "home")
+
+	list := IPList{AccountID: "account", ListName: "home"}
+	if listID, err := client.GetIPListID(context.Background(), list); err != nil || listID != home.ID {
+		t.Errorf("GetIPListID(home): %q, %v, want %q", listID, err, home.ID)
+	}
+}
+
+func TestGetIPListIDMissing(t *testing.T) {
+	client, fake := newTestCF(t)
+	fake.AddIPList("account", "office")
+
+	list := IPList{AccountID: "account", ListName: "home"}
+	if _, err := client.GetIPListID(context.Background(), list); !errors.Is(err, ErrIPListNotFound) {
+		t.Errorf("GetIPListID(home): %v, want an error wrapping ErrIPListNotFound", err)
+	}
+}
+
+// An address already in the list is kept: the other tagged items are removed and nothing is created.
+func TestReplaceIPListItemKeepsPresentAddress(t *testing.T) {
+	client, fake := newTestCF(t)
+	ctx := context.Background()
+
+	created := fake.AddIPList("account", "home")
+	fake.AddIPListItem(created.ID, cftest.IPListItem{IP: "198.51.100.1", Comment: "cloudflare-ddns"})
+	fake.AddIPListItem(created.ID, cftest.IPListItem{IP: "203.0.113.10", Comment: "cloudflare-ddns"})
+	fake.AddIPListItem(created.ID, cftest.IPListItem{IP: "192.0.2.1", Comment: "office"})
+
+	list := IPList{AccountID: "account", ListName: "home", Comment: "cloudflare-ddns"}
+	old, err := client.GetIPListItems(ctx, list, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.ReplaceIPListItem(ctx, list, created.ID, "203.0.113.10", old); err != nil {
+		t.Fatalf("ReplaceIPListItem: %v", err)
+	}
+
+	for _, request := range fake.Requests() {
+		if strings.HasPrefix(request, http.MethodPost) {
+			t.Errorf("request %s, want no item created", request)
+		}
+	}
+
+	items := fake.IPListItems(created.ID)
+	if len(items) != 2 || items[0].IP != "203.0.113.10" || items[1].IP != "192.0.2.1" {
+		t.Errorf("items %+v, want 203.0.113.10 and the untagged 192.0.2.1", items)
+	}
+}
+
+func TestBulkOperationErrorsAreWrapped(t *testing.T) {
+	client, fake := newTestCF(t)
+	created := fake.AddIPList("account", "home")
+	fake.Fail(cftest.Failure{Method: http.MethodGet, PathPrefix: "accounts/account/rules/lists/bulk_operations/", Status: http.StatusForbidden, Code: 9109, Message: "Unauthorized to access requested resource"})
+
+	list := IPList{AccountID: "account", ListName: "home", Comment: "cloudflare-ddns"}
+	err := client.ReplaceIPListItem(context.Background(), list, created.ID, "203.0.113.10", nil)
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("ReplaceIPListItem: %v, want an error wrapping ErrUnauthorized", err)
+	}
+}
+
+func TestListDNSRecordsFetchesAllPages(t *testing.T) {
+	client, fake := newTestCF(t)
+	zoneID, err := client.GetZoneID(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The fake API answers 100 records per page.
+	for i := range 150 {
+		fake.AddRecord(zoneID, cftest.Record{Name: fmt.Sprintf("host-%d.example.com", i), Type: "A", Content: "203.0.113.1", TTL: 1})
+	}
+
+	records, err := client.ListDNSRecords(context.Background(), zoneID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 150 {
+		t.Errorf("%d records, want 150", len(records))
+	}
+}
@@ -0,0 +1,56 @@
+package cf
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	cloudflare "github.com/cloudflare/cloudflare-go/v4"
+)
+
+// Errors shared by the DNS providers and the api package. Provider errors wrap them, so callers can
+// tell the cause apart with errors.Is while keeping the original message.
+var (
+	// ErrRecordNotFound is returned when a record does not exist at the provider.
+	ErrRecordNotFound = errors.New("record not found")
+	// ErrZoneNotFound is returned when a zone does not exist or is not visible to the credentials.
+	ErrZoneNotFound = errors.New("zone not found")
+	// ErrUnauthorized is returned when the provider rejects the credentials or their permissions.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrRateLimited is returned when the provider keeps rate limiting requests after the retries.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrValidation is returned when a record is invalid, either locally or for the provider.
+	ErrValidation = errors.New("validation failed")
+	// ErrIPListNotFound is returned when an IP List does not exist in the account or is not visible to
+	// the credentials.
+	ErrIPListNotFound = errors.New("ip list not found")
+)
+
+// The function makes a *ValidationError match ErrValidation with errors.Is.
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// wrapAPIError wraps a Cloudflare API error with the matching sentinel error based on its HTTP
+// status. notFound, when not nil, is used for 404 responses, whose meaning depends on the resource.
+func wrapAPIError(err error, notFound error) error {
+	var apiError *cloudflare.Error
+	if !errors.As(err, &apiError) {
+		return err
+	}
+
+	switch apiError.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	case http.StatusTooManyRequests:
+		return fmt.Errorf("%w: %w", ErrRateLimited, err)
+	case http.StatusBadRequest:
+		return fmt.Errorf("%w: %w", ErrValidation, err)
+	case http.StatusNotFound:
+		if notFound != nil {
+			return fmt.Errorf("%w: %w", notFound, err)
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,158 @@
+package cf
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	cloudflare "github.com/cloudflare/cloudflare-go/v4"
+	"github.com/cloudflare/cloudflare-go/v4/option"
+	"github.com/cloudflare/cloudflare-go/v4/rules"
+)
+
+// IPList is an account-level Cloudflare IP List (used in WAF rules and Access policies) in which the
+// entry tagged with Comment is kept pointing at the current external IP.
+type IPList struct {
+	AccountID string `mapstructure:"account_id" json:"account_id" yaml:"account_id"`
+	ListName  string `mapstructure:"list_name" json:"list_name" yaml:"list_name"`
+	ListID    string `mapstructure:"list_id,omitempty" json:"list_id,omitempty" yaml:"list_id,omitempty"`
+	Comment   string `mapstructure:"comment" json:"comment" yaml:"comment"`
+}
+
+// bulkOperationPollInterval is the delay between checks of an asynchronous list operation.
+var bulkOperationPollInterval = 500 * time.Millisecond
+
+// The function returns the ID of the IP List, looking it up by name when no ID is configured.
+func (cf *CF) GetIPListID(ctx context.Context, list IPList) (string, error) {
+	if list.ListID != "" {
+		return list.ListID, nil
+	}
+
+	iter := cf.Client.Rules.Lists.ListAutoPaging(ctx, rules.ListListParams{
+		AccountID: cloudflare.F(list.AccountID),
+	})
+	for iter.Next() {
+		if item := iter.Current(); item.Name == list.ListName && item.Kind == rules.ListsListKindIP {
+			return item.ID, nil
+		}
+	}
+	if err := iter.Err(); err != nil {
+		slog.With("listName", list.ListName).ErrorContext(ctx, "Error GetIPListID", "error", err)
+		return "", wrapAPIError(err, nil)
+	}
+
+	slog.With("listName", list.ListName).ErrorContext(ctx, "IP List not found")
+	return "", fmt.Errorf("%w: %s", ErrIPListNotFound, list.ListName)
+}
+
+// This function returns the items of the IP List tagged with the list comment.
+func (cf *CF) GetIPListItems(ctx context.Context, list IPList, listID string) ([]rules.ListItemListResponse, error) {
+	items := make([]rules.ListItemListResponse, 0)
+
+	iter := cf.Client.Rules.Lists.Items.ListAutoPaging(ctx, listID, rules.ListItemListParams{
+		AccountID: cloudflare.F(list.AccountID),
+	})
+	for iter.Next() {
+		if item := iter.Current(); item.Comment == list.Comment {
+			items = append(items, item)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		// A configured list ID is not checked beforehand.
+		return nil, wrapAPIError(err, ErrIPListNotFound)
+	}
+
+	return items, nil
+}
+
+// This function replaces the tagged items of the IP List with a single entry for ip. The new entry is
+// added before the old ones are removed, so the allowlist never lacks the current address. When ip is
+// already among the tagged items, only the other ones are removed.
+func (cf *CF) ReplaceIPListItem(ctx context.Context, list IPList, listID string, ip string, old []rules.ListItemListResponse) error {
+	present := false
+	for _, item := range old {
+		present = present || item.IP == ip
+	}
+
+	if !present {
+		created, err := cf.Client.Rules.Lists.Items.New(ctx, listID, rules.ListItemNewParams{
+			AccountID: cloudflare.F(list.AccountID),
+			Body: []rules.ListItemNewParamsBody{{
+				IP:      cloudflare.F(ip),
+				Comment: cloudflare.F(list.Comment),
+			}},
+		})
+		if err != nil {
+			slog.With("listID", listID).ErrorContext(ctx, "ReplaceIPListItem error", "err", err)
+			return wrapAPIError(err, nil)
+		}
+
+		if err := cf.waitForBulkOperation(ctx, list.AccountID, created.OperationID); err != nil {
+			slog.With("listID", listID).ErrorContext(ctx, "ReplaceIPListItem error", "err", err)
+			return err
+		}
+	}
+
+	stale := make([]map[string]string, 0, len(old))
+	for _, item := range old {
+		if item.IP != ip {
+			stale = append(stale, map[string]string{"id": item.ID})
+		}
+	}
+
+	if len(stale) > 0 {
+		deleted, err := cf.Client.Rules.Lists.Items.Delete(ctx, listID, rules.ListItemDeleteParams{
+			AccountID: cloudflare.F(list.AccountID),
+		}, option.WithJSONSet("items", stale), option.WithHeader("Content-Type", "application/json"))
+		if err != nil {
+			slog.With("listID", listID).ErrorContext(ctx, "ReplaceIPListItem error", "err", err)
+			return wrapAPIError(err, nil)
+		}
+
+		if err := cf.waitForBulkOperation(ctx, list.AccountID, deleted.OperationID); err != nil {
+			slog.With("listID", listID).ErrorContext(ctx, "ReplaceIPListItem error", "err", err)
+			return err
+		}
+	}
+
+	slog.InfoContext(ctx, "IP List updated",
+		slog.String("ListName", list.ListName),
+		slog.String("ListID", listID),
+		slog.String("IP", ip),
+		slog.String("Comment", list.Comment),
+		slog.Int("Removed", len(stale)),
+	)
+
+	return nil
+}
+
+// waitForBulkOperation polls an asynchronous list operation until it completes or fails. A failed
+// operation is reported as ErrValidation, Cloudflare having rejected the items.
+func (cf *CF) waitForBulkOperation(ctx context.Context, accountID, operationID string) error {
+	if operationID == "" {
+		return nil
+	}
+
+	for {
+		operation, err := cf.Client.Rules.Lists.BulkOperations.Get(ctx, operationID, rules.ListBulkOperationGetParams{
+			AccountID: cloudflare.F(accountID),
+		})
+		if err != nil {
+			return wrapAPIError(err, nil)
+		}
+
+		switch operation.Status {
+		case rules.ListBulkOperationGetResponseStatusCompleted:
+			return nil
+		case rules.ListBulkOperationGetResponseStatusFailed:
+			return fmt.Errorf("%w: ip list operation %s failed: %s", ErrValidation, operationID, operation.Error)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(bulkOperationPollInterval):
+		}
+	}
+}
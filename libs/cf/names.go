@@ -0,0 +1,147 @@
+package cf
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	"golang.org/x/net/idna"
+)
+
+// ErrNameOutsideZone is returned when a record name does not belong to the zone it is declared in.
+var ErrNameOutsideZone = errors.New("name is outside of zone")
+
+// idnaProfile maps names the same way resolvers do while still allowing underscores and wildcards,
+// which are common in record names (_acme-challenge, *.example.com).
+var idnaProfile = idna.New(idna.MapForLookup(), idna.Transitional(false), idna.StrictDomainName(false))
+
+// The function returns the canonical form of a domain name: lower case, punycode and without the
+// trailing dot.
+func NormalizeZoneName(zoneName string) (string, error) {
+	zoneName = strings.TrimSuffix(strings.TrimSpace(zoneName), ".")
+	if zoneName == "" {
+		return "", fmt.Errorf("zone name is empty")
+	}
+
+	return toASCII(zoneName)
+}
+
+// The function returns the canonical FQDN of a record name within the given (normalized) zone. "@"
+// stands for the zone apex and names ending with ".@" (e.g. "vpn.home.@") are relative to the zone, as
+// are single labels such as "home". Any other name is absolute, with or without a trailing dot, and an
+// error wrapping ErrNameOutsideZone is returned when it does not belong to the zone.
+func NormalizeName(name, zoneName string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("record name is empty")
+	}
+
+	if name == "@" {
+		return zoneName, nil
+	}
+
+	if relative, ok := strings.CutSuffix(name, ".@"); ok {
+		fqdn, err := toASCII(relative)
+		if err != nil {
+			return "", err
+		}
+		return fqdn + "." + zoneName, nil
+	}
+
+	fqdn, err := toASCII(strings.TrimSuffix(name, "."))
+	if err != nil {
+		return "", err
+	}
+
+	if InZone(fqdn, zoneName) {
+		return fqdn, nil
+	}
+
+	// A name made of several labels is a FQDN, appending the zone to it would silently manage a
+	// record the config does not mean, e.g. home.other.com.example.com.
+	if strings.HasSuffix(name, ".") || strings.Contains(fqdn, ".") {
+		return "", fmt.Errorf("%w: %q is not in zone %q", ErrNameOutsideZone, fqdn, zoneName)
+	}
+
+	return fqdn + "." + zoneName, nil
+}
+
+// The function reports whether the (normalized) name is the zone apex or a subdomain of the zone.
+func InZone(name, zoneName string) bool {
+	return name == zoneName || strings.HasSuffix(name, "."+zoneName)
+}
+
+// The function returns the zone, among zoneNames, that the absolute record name belongs to. When
+// several zones match, e.g. with subdomains delegated into separate zones, the longest one wins.
+func ZoneForName(name string, zoneNames []string) (string, error) {
+	fqdn, err := NormalizeZoneName(name)
+	if err != nil {
+		return "", err
+	}
+
+	var match string
+	for _, zoneName := range zoneNames {
+		zoneName, err := NormalizeZoneName(zoneName)
+		if err != nil {
+			continue
+		}
+
+		if InZone(fqdn, zoneName) && len(zoneName) > len(match) {
+			match = zoneName
+		}
+	}
+
+	if match == "" {
+		return "", fmt.Errorf("no zone found for %q", fqdn)
+	}
+
+	return match, nil
+}
+
+// The function normalizes the zone name, record name and record type (A when empty) of the record in
+// place.
+func (r *ExtendedCloudflareDNSRecord) Normalize() error {
+	if r.Record == nil {
+		return fmt.Errorf("record definition is missing")
+	}
+
+	zoneName, err := NormalizeZoneName(r.ZoneName)
+	if err != nil {
+		return err
+	}
+
+	name, err := NormalizeName(r.Record.Name, zoneName)
+	if err != nil {
+		return err
+	}
+
+	r.ZoneName = zoneName
+	r.Record.Name = name
+
+	r.Record.Type = dns.RecordResponseType(strings.ToUpper(string(r.Record.Type)))
+	if r.Record.Type == "" {
+		r.Record.Type = dns.RecordResponseTypeA
+	}
+
+	return nil
+}
+
+func toASCII(name string) (string, error) {
+	ascii, err := idnaProfile.ToASCII(name)
+	if err != nil {
+		return "", fmt.Errorf("invalid name %q: %w", name, err)
+	}
+
+	if len(ascii) > 253 {
+		return "", fmt.Errorf("invalid name %q: longer than 253 characters", name)
+	}
+
+	for _, label := range strings.Split(ascii, ".") {
+		if label == "" || len(label) > 63 || strings.ContainsAny(label, " \t") {
+			return "", fmt.Errorf("invalid name %q: bad label %q", name, label)
+		}
+	}
+
+	return ascii, nil
+}
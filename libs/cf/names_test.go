@@ -0,0 +1,41 @@
+package cf
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		outside bool
+	}{
+		{name: "@", want: "example.com"},
+		{name: "home", want: "home.example.com"},
+		{name: "*", want: "*.example.com"},
+		{name: "vpn.home.@", want: "vpn.home.example.com"},
+		{name: "home.example.com", want: "home.example.com"},
+		{name: "Home.Example.COM.", want: "home.example.com"},
+		{name: "example.com", want: "example.com"},
+		{name: "home.other.com", outside: true},
+		{name: "home.other.com.", outside: true},
+		{name: "home.", outside: true},
+		{name: "notexample.com", outside: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeName(tt.name, "example.com")
+			if tt.outside {
+				if !errors.Is(err, ErrNameOutsideZone) {
+					t.Errorf("NormalizeName(%q) = %q, %v, want an error wrapping ErrNameOutsideZone", tt.name, got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("NormalizeName(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package cf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxTTL is the highest TTL accepted by Cloudflare, in seconds.
+const maxTTL = 86400
+
+// ValidationOptions holds the provider and plan dependent limits records are validated against.
+type ValidationOptions struct {
+	// MinTTL is the lowest TTL accepted besides 1 (automatic): 60 on most plans, 30 on Enterprise.
+	MinTTL int
+	// CNAMEFlattening tells whether the provider flattens CNAME records at the zone apex.
+	CNAMEFlattening bool
+}
+
+// RecordError describes a single problem found in a record.
+type RecordError struct {
+	Index    int    `json:"index"`
+	Name     string `json:"name"`
+	ZoneName string `json:"zone_name"`
+	Field    string `json:"field"`
+	Message  string `json:"message"`
+}
+
+func (e RecordError) Error() string {
+	return fmt.Sprintf("record %d (%s): %s: %s", e.Index, e.Name, e.Field, e.Message)
+}
+
+// ValidationError is returned when one or more records are invalid. It lists every problem found
+// rather than only the first one.
+type ValidationError struct {
+	Errors []RecordError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, recordError := range e.Errors {
+		messages = append(messages, recordError.Error())
+	}
+	return "invalid records: " + strings.Join(messages, "; ")
+}
+
+// The function normalizes the record and validates its settings, returning the problems found.
+func (r *ExtendedCloudflareDNSRecord) Check(opts ValidationOptions) []RecordError {
+	if r.Record == nil {
+		return []RecordError{r.recordError("record", "record definition is missing")}
+	}
+
+	if err := r.Normalize(); err != nil {
+		return []RecordError{r.recordError("name", err.Error())}
+	}
+
+	return r.Validate(opts)
+}
+
+// The function checks the settings of a normalized record for combinations Cloudflare (or the
+// configured provider) would reject, so they are reported before any change is made.
+func (r *ExtendedCloudflareDNSRecord) Validate(opts ValidationOptions) []RecordError {
+	errs := make([]RecordError, 0)
+
+	ttl := int(r.Record.TTL)
+	recordType := string(r.Record.Type)
+
+	if r.Record.Proxied {
+		if !proxiable(recordType) {
+			errs = append(errs, r.recordError("proxied", fmt.Sprintf("%s records cannot be proxied", recordType)))
+		}
+		if ttl != 0 && ttl != 1 {
+			errs = append(errs, r.recordError("ttl", "proxied records must use TTL 1 (automatic)"))
+		}
+	}
+
+	if ttl < 0 || ttl > maxTTL {
+		errs = append(errs, r.recordError("ttl", fmt.Sprintf("TTL must be between 1 and %d", maxTTL)))
+	} else if ttl > 1 && ttl < opts.MinTTL {
+		errs = append(errs, r.recordError("ttl", fmt.Sprintf("TTL %d is below the plan minimum of %d", ttl, opts.MinTTL)))
+	}
+
+	if recordType == "CNAME" {
+		if r.CNAME == "" && r.Template == "" {
+			errs = append(errs, r.recordError("CNAME", "CNAME records require a CNAME value or a template"))
+		}
+		if r.Record.Name == r.ZoneName && !opts.CNAMEFlattening {
+			errs = append(errs, r.recordError("name", "CNAME records at the zone apex require CNAME flattening"))
+		}
+	}
+
+	return errs
+}
+
+func (r *ExtendedCloudflareDNSRecord) recordError(field, message string) RecordError {
+	recordError := RecordError{
+		ZoneName: r.ZoneName,
+		Field:    field,
+		Message:  message,
+	}
+
+	if r.Record != nil {
+		recordError.Name = r.Record.Name
+	}
+
+	return recordError
+}
+
+func proxiable(recordType string) bool {
+	switch recordType {
+	case "A", "AAAA", "CNAME":
+		return true
+	}
+	return false
+}
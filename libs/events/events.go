@@ -0,0 +1,117 @@
+package events
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Types of Event.
+const (
+	TypeIPDetected     = "ip_detected"
+	TypeIPChanged      = "ip_changed"
+	TypeRecordCreated  = "record_created"
+	TypeRecordUpdated  = "record_updated"
+	TypeRecordDeleted  = "record_deleted"
+	TypeUpdateFailed   = "update_failed"
+	TypeConfigReloaded = "config_reloaded"
+)
+
+// Types lists every type of Event.
+var Types = []string{TypeIPDetected, TypeIPChanged, TypeRecordCreated, TypeRecordUpdated, TypeRecordDeleted, TypeUpdateFailed, TypeConfigReloaded}
+
+// subscriberBuffer is the number of events a subscriber can lag behind before losing some.
+const subscriberBuffer = 64
+
+// Event is something that happened in the daemon. ID and Time are set by Publish. Record fields are
+// only set for record events, IPList for failed updates of an IP List.
+type Event struct {
+	ID         uint64    `json:"id"`
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	IP         string    `json:"ip,omitempty"`
+	PreviousIP string    `json:"previous_ip,omitempty"`
+	Source     string    `json:"source,omitempty"`
+	ZoneName   string    `json:"zone_name,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	RecordType string    `json:"record_type,omitempty"`
+	IPList     string    `json:"ip_list,omitempty"`
+	OldContent string    `json:"old_content,omitempty"`
+	NewContent string    `json:"new_content,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DryRun     bool      `json:"dry_run,omitempty"`
+}
+
+// Bus delivers the published events to every subscriber. Publishing never blocks: a subscriber that
+// does not keep up loses the events its buffer cannot hold.
+type Bus struct {
+	mu          sync.Mutex
+	lastID      uint64
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription receives the events published after Subscribe, until Unsubscribe.
+type Subscription struct {
+	C       <-chan Event
+	c       chan Event
+	dropped int
+}
+
+// Default is the bus the daemon publishes into.
+var Default = NewBus()
+
+// The function returns an empty bus.
+func NewBus() *Bus {
+	return &Bus{subscribers: map[*Subscription]struct{}{}}
+}
+
+// The function publishes the event on the Default bus.
+func Publish(event Event) {
+	Default.Publish(event)
+}
+
+// The function numbers and timestamps the event, and delivers it to the subscribers.
+func (b *Bus) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	event.ID = b.lastID
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	for subscription := range b.subscribers {
+		select {
+		case subscription.c <- event:
+		default:
+			subscription.dropped++
+			if subscription.dropped == 1 {
+				slog.Warn("Event subscriber is too slow, dropping events", "type", event.Type)
+			}
+		}
+	}
+}
+
+// The function returns a subscription to the events published from now on.
+func (b *Bus) Subscribe() *Subscription {
+	c := make(chan Event, subscriberBuffer)
+	subscription := &Subscription{C: c, c: c}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subscribers[subscription] = struct{}{}
+	return subscription
+}
+
+// The function stops the subscription and closes its channel.
+func (b *Bus) Unsubscribe(subscription *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[subscription]; ok {
+		delete(b.subscribers, subscription)
+		close(subscription.c)
+	}
+}
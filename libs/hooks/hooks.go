@@ -0,0 +1,212 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Stages at which hooks run.
+const (
+	StagePreIPChange      = "pre_ip_change"
+	StagePostIPChange     = "post_ip_change"
+	StagePreRecordUpdate  = "pre_record_update"
+	StagePostRecordUpdate = "post_record_update"
+)
+
+// DefaultTimeout bounds hooks without a configured timeout.
+const DefaultTimeout = 10 * time.Second
+
+// ErrVetoed is returned when a pre hook rejects a change.
+var ErrVetoed = errors.New("vetoed by hook")
+
+// Hook is either an executable, run with the event passed as environment variables, or an HTTP
+// webhook receiving the event as a JSON body. A pre hook vetoes the change by exiting with a non-zero
+// status or answering with a non-2xx status.
+type Hook struct {
+	Command []string          `mapstructure:"command" json:"command,omitempty" yaml:"command,omitempty"`
+	URL     string            `mapstructure:"url" json:"url,omitempty" yaml:"url,omitempty"`
+	Method  string            `mapstructure:"method" json:"method,omitempty" yaml:"method,omitempty"`
+	Headers map[string]string `mapstructure:"headers" json:"headers,omitempty" yaml:"headers,omitempty"`
+	// Timeout is a duration such as "5s", DefaultTimeout when empty.
+	Timeout string `mapstructure:"timeout" json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// Hooks are the global hooks, run around IP changes and around the update of every record.
+type Hooks struct {
+	PreIPChange      []Hook `mapstructure:"pre_ip_change" json:"pre_ip_change,omitempty" yaml:"pre_ip_change,omitempty"`
+	PostIPChange     []Hook `mapstructure:"post_ip_change" json:"post_ip_change,omitempty" yaml:"post_ip_change,omitempty"`
+	PreRecordUpdate  []Hook `mapstructure:"pre_record_update" json:"pre_record_update,omitempty" yaml:"pre_record_update,omitempty"`
+	PostRecordUpdate []Hook `mapstructure:"post_record_update" json:"post_record_update,omitempty" yaml:"post_record_update,omitempty"`
+}
+
+// RecordHooks are the hooks of a single record, run after the global ones.
+type RecordHooks struct {
+	PreUpdate  []Hook `mapstructure:"pre_update" json:"pre_update,omitempty" yaml:"pre_update,omitempty"`
+	PostUpdate []Hook `mapstructure:"post_update" json:"post_update,omitempty" yaml:"post_update,omitempty"`
+}
+
+// Event describes what a hook runs for. Record fields are empty for IP change stages, Result and
+// Error are only set for post stages.
+type Event struct {
+	Stage      string `json:"stage"`
+	OldIP      string `json:"old_ip"`
+	NewIP      string `json:"new_ip"`
+	RecordName string `json:"record_name,omitempty"`
+	RecordType string `json:"record_type,omitempty"`
+	ZoneName   string `json:"zone_name,omitempty"`
+	Action     string `json:"action,omitempty"`
+	Result     string `json:"result,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// The function checks the hook is either a command or a webhook and has a valid timeout.
+func (h Hook) Validate() error {
+	if (len(h.Command) == 0) == (h.URL == "") {
+		return fmt.Errorf("hook requires either command or url")
+	}
+
+	if h.Timeout != "" {
+		if timeout, err := time.ParseDuration(h.Timeout); err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid hook timeout %q", h.Timeout)
+		}
+	}
+
+	return nil
+}
+
+// The function checks every hook of the list.
+func Validate(hooks ...[]Hook) error {
+	for _, list := range hooks {
+		for _, hook := range list {
+			if err := hook.Validate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// The function runs the hooks in order for the event and logs their outcome. For pre stages the
+// first failing hook vetoes the change: the remaining hooks are not run and an error wrapping
+// ErrVetoed is returned. Failures of post hooks are only logged.
+func Run(ctx context.Context, hooks []Hook, event Event) error {
+	pre := strings.HasPrefix(event.Stage, "pre_")
+
+	for _, hook := range hooks {
+		started := time.Now()
+		err := hook.run(ctx, event)
+
+		logger := slog.With("stage", event.Stage, "hook", hook.name(), "record", event.RecordName, "duration", time.Since(started))
+		if err == nil {
+			logger.InfoContext(ctx, "Hook succeeded")
+			continue
+		}
+
+		if pre {
+			logger.WarnContext(ctx, "Hook vetoed the change", "error", err)
+			return fmt.Errorf("%w: %s: %w", ErrVetoed, hook.name(), err)
+		}
+
+		logger.ErrorContext(ctx, "Hook failed", "error", err)
+	}
+
+	return nil
+}
+
+func (h Hook) run(ctx context.Context, event Event) error {
+	timeout := DefaultTimeout
+	if h.Timeout != "" {
+		if parsed, err := time.ParseDuration(h.Timeout); err == nil {
+			timeout = parsed
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if h.URL != "" {
+		return h.post(ctx, event)
+	}
+
+	return h.exec(ctx, event)
+}
+
+// exec runs the command with the event in CFDDNS_* environment variables.
+func (h Hook) exec(ctx context.Context, event Event) error {
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"CFDDNS_HOOK_STAGE="+event.Stage,
+		"CFDDNS_OLD_IP="+event.OldIP,
+		"CFDDNS_NEW_IP="+event.NewIP,
+		"CFDDNS_RECORD_NAME="+event.RecordName,
+		"CFDDNS_RECORD_TYPE="+event.RecordType,
+		"CFDDNS_ZONE_NAME="+event.ZoneName,
+		"CFDDNS_ACTION="+event.Action,
+		"CFDDNS_RESULT="+event.Result,
+		"CFDDNS_ERROR="+event.Error,
+	)
+	// Children of the command inherit its output, so waiting for it is bounded too once the timeout
+	// killed the command.
+	cmd.WaitDelay = time.Second
+
+	output, err := cmd.CombinedOutput()
+	if len(output) > 0 {
+		slog.DebugContext(ctx, "Hook output", "hook", h.name(), "output", string(output))
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("timed out: %w", ctx.Err())
+	}
+
+	return err
+}
+
+// post sends the event as a JSON body to the webhook, expecting a 2xx response.
+func (h Hook) post(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	method := h.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range h.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+func (h Hook) name() string {
+	if h.URL != "" {
+		return h.URL
+	}
+	return h.Command[0]
+}
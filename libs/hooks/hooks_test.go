@@ -0,0 +1,166 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The function returns an event of a record update from 198.51.100.1 to 203.0.113.10.
+func recordEvent(stage string) Event {
+	return Event{
+		Stage:      stage,
+		OldIP:      "198.51.100.1",
+		NewIP:      "203.0.113.10",
+		RecordName: "home.example.com",
+		RecordType: "A",
+		ZoneName:   "example.com",
+		Action:     "updated",
+	}
+}
+
+// The function returns a hook running script with sh.
+func shell(script string) Hook {
+	return Hook{Command: []string{"sh", "-c", script}}
+}
+
+func TestPreHookFailureVetoes(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "ran")
+	hooks := []Hook{shell("exit 3"), shell("touch " + marker)}
+
+	err := Run(context.Background(), hooks, recordEvent(StagePreRecordUpdate))
+	if !errors.Is(err, ErrVetoed) {
+		t.Fatalf("Run: %v, want an error wrapping ErrVetoed", err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("the hook after the veto ran")
+	}
+}
+
+func TestPostHookFailureIsNotReturned(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "ran")
+	hooks := []Hook{shell("exit 3"), shell("touch " + marker)}
+
+	if err := Run(context.Background(), hooks, recordEvent(StagePostRecordUpdate)); err != nil {
+		t.Fatalf("Run: %v, want failures of post hooks to be logged only", err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Error("the hook after the failure did not run")
+	}
+}
+
+func TestHookTimeout(t *testing.T) {
+	hook := shell("sleep 10")
+	hook.Timeout = "100ms"
+
+	started := time.Now()
+	err := Run(context.Background(), []Hook{hook}, recordEvent(StagePreRecordUpdate))
+	if !errors.Is(err, ErrVetoed) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Run: %v, want a veto caused by the timeout", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("hook stopped after %s, want about 100ms", elapsed)
+	}
+}
+
+func TestCommandReceivesEventInEnvironment(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "env")
+	event := recordEvent(StagePostRecordUpdate)
+	event.Result, event.Error = "failure", "rate limited"
+
+	if err := Run(context.Background(), []Hook{shell("env > " + output)}, event); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := strings.Split(string(data), "\n")
+
+	for _, want := range []string{
+		"CFDDNS_HOOK_STAGE=post_record_update",
+		"CFDDNS_OLD_IP=198.51.100.1",
+		"CFDDNS_NEW_IP=203.0.113.10",
+		"CFDDNS_RECORD_NAME=home.example.com",
+		"CFDDNS_RECORD_TYPE=A",
+		"CFDDNS_ZONE_NAME=example.com",
+		"CFDDNS_ACTION=updated",
+		"CFDDNS_RESULT=failure",
+		"CFDDNS_ERROR=rate limited",
+	} {
+		found := false
+		for _, variable := range env {
+			found = found || variable == want
+		}
+		if !found {
+			t.Errorf("environment lacks %s", want)
+		}
+	}
+}
+
+func TestWebhookReceivesEventAsJSON(t *testing.T) {
+	var method, contentType, token string
+	var received Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, contentType, token = r.Method, r.Header.Get("Content-Type"), r.Header.Get("X-Token")
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	hook := Hook{URL: server.URL, Method: http.MethodPut, Headers: map[string]string{"X-Token": "secret"}}
+	event := recordEvent(StagePreRecordUpdate)
+
+	if err := Run(context.Background(), []Hook{hook}, event); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if method != http.MethodPut || contentType != "application/json" || token != "secret" {
+		t.Errorf("request %s with Content-Type %q and X-Token %q, want PUT, application/json and secret", method, contentType, token)
+	}
+	if received != event {
+		t.Errorf("body %+v, want %+v", received, event)
+	}
+}
+
+func TestWebhookErrorStatusVetoes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+	}))
+	defer server.Close()
+
+	err := Run(context.Background(), []Hook{{URL: server.URL}}, recordEvent(StagePreIPChange))
+	if !errors.Is(err, ErrVetoed) {
+		t.Errorf("Run: %v, want an error wrapping ErrVetoed", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		hook  Hook
+		valid bool
+	}{
+		{name: "command", hook: Hook{Command: []string{"true"}}, valid: true},
+		{name: "webhook", hook: Hook{URL: "http://127.0.0.1/hook", Timeout: "5s"}, valid: true},
+		{name: "neither", hook: Hook{}},
+		{name: "both", hook: Hook{Command: []string{"true"}, URL: "http://127.0.0.1/hook"}},
+		{name: "invalid timeout", hook: Hook{Command: []string{"true"}, Timeout: "soon"}},
+		{name: "negative timeout", hook: Hook{Command: []string{"true"}, Timeout: "-1s"}},
+	}
+
+	for _, tt := range tests {
+		if err := Validate([]Hook{tt.hook}); (err == nil) != tt.valid {
+			t.Errorf("%s: Validate returned %v", tt.name, err)
+		}
+	}
+}
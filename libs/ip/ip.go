@@ -6,10 +6,46 @@ import (
 	"io"
 	"math/rand"
 	"net/http"
+	"sync"
 	"time"
 )
 
-var CurrentIp *IP
+// The addresses are written by the refresh cycles and read by the HTTP handlers at the same time, so
+// they are only reached through Current, Previous and SetCurrent.
+var (
+	mu sync.RWMutex
+	// current is the IP address detected last, nil until it is detected.
+	current *IP
+	// previous is the IP address detected before the latest change, nil until the address changes.
+	previous *IP
+)
+
+// The function returns the IP address detected last, nil until it is detected.
+func Current() *IP {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// The function returns the IP address detected before the latest change, nil until the address
+// changes.
+func Previous() *IP {
+	mu.RLock()
+	defer mu.RUnlock()
+	return previous
+}
+
+// The function makes address the current IP address. The former one becomes the previous address when
+// it differs.
+func SetCurrent(address *IP) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if current != nil && address != nil && current.IP != address.IP {
+		previous = current
+	}
+	current = address
+}
 
 type IP struct {
 	IP     string
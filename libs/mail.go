@@ -10,6 +10,8 @@ import (
 	"log/slog"
 
 	"github.com/spf13/viper"
+	"github.com/wasilak/cloudflare-ddns/libs/api"
+	"github.com/wasilak/cloudflare-ddns/libs/events"
 	gomail "gopkg.in/mail.v2"
 )
 
@@ -19,8 +21,10 @@ var templateFiles embed.FS
 // The MailData type contains a single field for storing an IP address as a string.
 // @property {string} IP - The "IP" property is a string that represents an IP address. It is likely
 // used to store the IP address of an email sender or recipient in an email application or system.
+// @property {[]api.RecordResult} Records - The outcome of the update of each record for the new IP.
 type MailData struct {
-	IP string
+	IP      string
+	Records []api.RecordResult
 }
 
 // The "Mail" type represents an email message with sender, recipient(s), subject, body,
@@ -63,8 +67,8 @@ type MailAuth struct {
 }
 
 // The `Send` function is a method of the `Mail` struct that sends an email message using the specified
-// SMTP server and authentication credentials. It takes a boolean parameter `dryRun` which is not used
-// in the function and returns a boolean value and an error. The function first calls the
+// SMTP server and authentication credentials. It takes a boolean parameter `dryRun` which, when set,
+// makes it only log the message instead of sending it and return `false`. The function first calls the
 // `parseTemplate` method to parse an HTML template file and populate the `Body` field of the `Mail`
 // struct with the parsed content. It then creates a new `gomail.Message` object and sets the email
 // headers and body using the values from the `Mail` struct. Finally, it creates a new `gomail.Dialer`
@@ -78,6 +82,11 @@ func (m *Mail) Send(dryRun bool) (bool, error) {
 		log.Fatal(err)
 	}
 
+	if dryRun {
+		slog.Info("Dry run, email not sent", "to", m.To, "subject", m.Subject)
+		return false, nil
+	}
+
 	gm := gomail.NewMessage()
 	gm.SetHeader("From", m.From)
 	gm.SetHeader("To", m.To...)
@@ -109,13 +118,21 @@ func (m *Mail) parseTemplate(templateFileName string, data interface{}) error {
 	return nil
 }
 
-// The Notify function sends an email notification with the given IP address if email notifications are
-// enabled in the configuration.
-func Notify(ctx context.Context, ip string) error {
+// The Notify function announces the change of the IP address from previousIP to ip: it publishes an
+// events.TypeIPChanged event, and sends an email notification with the outcome of the record updates
+// if email notifications are enabled in the configuration.
+func Notify(ctx context.Context, previousIP, ip string, result api.RunResult) error {
+	event := events.Event{Type: events.TypeIPChanged, IP: ip, PreviousIP: previousIP, DryRun: result.DryRun}
+	if err := result.Err(); err != nil {
+		event.Error = err.Error()
+	}
+	events.Publish(event)
+
 	if viper.GetBool("mail.enabled") {
 
 		mailData := MailData{
-			IP: ip,
+			IP:      ip,
+			Records: result.Records,
 		}
 
 		// The code is creating a new `Mail` struct and initializing its fields with values obtained from the
@@ -139,12 +156,14 @@ func Notify(ctx context.Context, ip string) error {
 			IP: mailData,
 		}
 
-		_, err := mail.Send(false)
+		sent, err := mail.Send(result.DryRun)
 		if err != nil {
 			return err
 		}
 
-		slog.DebugContext(ctx, "Email sent", "to", viper.GetStringSlice("mail.to"))
+		if sent {
+			slog.DebugContext(ctx, "Email sent", "to", viper.GetStringSlice("mail.to"))
+		}
 	}
 
 	return nil
@@ -0,0 +1,106 @@
+package libs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/wasilak/cloudflare-ddns/libs/api"
+)
+
+// Plan output formats.
+const (
+	PlanFormatText = "text"
+	PlanFormatJSON = "json"
+)
+
+// planSymbols prefixes each action in the text output, the way diffs do.
+var planSymbols = map[string]string{
+	api.ActionCreated:   "+",
+	api.ActionUpdated:   "~",
+	api.ActionUnchanged: "=",
+	api.ActionSkipped:   "-",
+	api.ActionVetoed:    "x",
+	api.ActionFailed:    "!",
+}
+
+// planVerbs names the actions of a dry run, which have not been taken yet.
+var planVerbs = map[string]string{
+	api.ActionCreated: "create",
+	api.ActionUpdated: "update",
+	api.ActionSkipped: "skip",
+}
+
+// The function writes the result of a run in the given format: a human readable summary (text) or
+// the result itself (json).
+func PrintPlan(w io.Writer, ipAddress string, result api.RunResult, format string) error {
+	switch format {
+	case PlanFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(struct {
+			IP string `json:"ip"`
+			api.RunResult
+		}{ipAddress, result})
+	case PlanFormatText, "":
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+
+	if result.DryRun {
+		fmt.Fprintf(w, "Plan for IP %s (nothing has been changed):\n\n", ipAddress)
+	} else {
+		fmt.Fprintf(w, "Changes for IP %s:\n\n", ipAddress)
+	}
+
+	counts := map[string]int{}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	for _, r := range result.Records {
+		counts[r.Action]++
+		fmt.Fprintf(tw, "  %s %s\t%s\t%s\t%s\n", planSymbols[r.Action], actionLabel(r.Action, result.DryRun), r.Record.Record.Name, r.Record.Record.Type, planChange(r.OldContent, r.NewContent, r.Error))
+	}
+
+	for _, r := range result.IPLists {
+		counts[r.Action]++
+		name := r.List.ListName
+		if name == "" {
+			name = r.List.ListID
+		}
+		fmt.Fprintf(tw, "  %s %s\tip list %s\t\t%s\n", planSymbols[r.Action], actionLabel(r.Action, result.DryRun), name, planChange(strings.Join(r.OldContent, ","), r.NewContent, r.Error))
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	summary := "\n%d created, %d updated, %d unchanged, %d skipped, %d failed.\n"
+	if result.DryRun {
+		summary = "\nPlan: %d to create, %d to update, %d unchanged, %d skipped, %d failed.\n"
+	}
+
+	_, err := fmt.Fprintf(w, summary,
+		counts[api.ActionCreated], counts[api.ActionUpdated], counts[api.ActionUnchanged], counts[api.ActionSkipped], counts[api.ActionFailed])
+
+	return err
+}
+
+func planChange(oldContent, newContent, errorMessage string) string {
+	switch {
+	case errorMessage != "":
+		return "error: " + errorMessage
+	case oldContent == "" || oldContent == newContent:
+		return newContent
+	default:
+		return oldContent + " -> " + newContent
+	}
+}
+
+func actionLabel(action string, dryRun bool) string {
+	if verb, ok := planVerbs[action]; ok && dryRun {
+		return verb
+	}
+	return action
+}
@@ -0,0 +1,283 @@
+package libs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wasilak/cloudflare-ddns/libs/api"
+	"github.com/wasilak/cloudflare-ddns/libs/cf"
+	"github.com/wasilak/cloudflare-ddns/libs/hooks"
+	"github.com/wasilak/cloudflare-ddns/libs/ip"
+)
+
+// ErrIPUnavailable is returned when the external IP address cannot be detected.
+var ErrIPUnavailable = api.ErrIPUnavailable
+
+// RecordSelector designates managed records by zone, name and, optionally, type.
+type RecordSelector struct {
+	ZoneName string `json:"zone_name"`
+	Name     string `json:"name"`
+	Type     string `json:"type,omitempty"`
+}
+
+// RefreshOptions tune a refresh cycle. Records, when not empty, restricts the cycle to the selected
+// records (and leaves IP Lists out) unless the IP changed, in which case every record is updated.
+// Force updates the records even though the IP did not change, repairing records that drifted.
+type RefreshOptions struct {
+	Records []RecordSelector `json:"records,omitempty"`
+	Force   bool             `json:"force,omitempty"`
+}
+
+// RefreshResult is the outcome of a refresh cycle. Coalesced tells the cycle was already in progress
+// when requested, its options were then the ones of the cycle in progress.
+type RefreshResult struct {
+	IP        string `json:"ip"`
+	IPChanged bool   `json:"ip_changed"`
+	Coalesced bool   `json:"coalesced"`
+	api.RunResult
+}
+
+// Refresher runs refresh cycles: detect the external IP, update every record when it changed, update
+// the records when forced, and retry the updates that failed otherwise. Cycles never overlap: a
+// refresh requested while a cycle is in progress waits for it and returns its result.
+type Refresher struct {
+	mu      sync.Mutex
+	running *refreshCycle
+	// status is guarded by mu, see Status.
+	status refresherStatus
+
+	// failedRecords and failedIPLists are retried on the next cycles until they succeed.
+	failedRecords map[string]bool
+	failedIPLists map[string]bool
+}
+
+type refreshCycle struct {
+	done   chan struct{}
+	result RefreshResult
+	err    error
+}
+
+// The function returns a Refresher with nothing to retry.
+func NewRefresher() *Refresher {
+	return &Refresher{
+		status:        refresherStatus{records: map[string]RecordStatus{}},
+		failedRecords: map[string]bool{},
+		failedIPLists: map[string]bool{},
+	}
+}
+
+// The function runs a refresh cycle, or waits for the one in progress. The cycle is not cancelled
+// with ctx, as other callers may be waiting for it, only the wait is.
+func (r *Refresher) Refresh(ctx context.Context, options RefreshOptions) (RefreshResult, error) {
+	records, err := SelectRecords(options.Records)
+	if err != nil {
+		return RefreshResult{}, err
+	}
+
+	r.mu.Lock()
+	if cycle := r.running; cycle != nil {
+		r.mu.Unlock()
+		slog.DebugContext(ctx, "Refresh coalesced with the cycle in progress")
+
+		select {
+		case <-cycle.done:
+			result := cycle.result
+			result.Coalesced = true
+			return result, cycle.err
+		case <-ctx.Done():
+			return RefreshResult{}, ctx.Err()
+		}
+	}
+
+	cycle := &refreshCycle{done: make(chan struct{})}
+	r.running = cycle
+	r.mu.Unlock()
+
+	cycle.result, cycle.err = r.cycle(context.WithoutCancel(ctx), options, records)
+
+	r.mu.Lock()
+	r.running = nil
+	r.finished(cycle.result, cycle.err)
+	r.mu.Unlock()
+	close(cycle.done)
+
+	return cycle.result, cycle.err
+}
+
+// The function runs a cycle. Only Refresh calls it, so cycles never overlap.
+func (r *Refresher) cycle(ctx context.Context, options RefreshOptions, records []cf.ExtendedCloudflareDNSRecord) (RefreshResult, error) {
+	currentIP, err := ip.GetIP(ctx)
+	knownIP := ip.Current()
+	if err != nil || currentIP == nil {
+		slog.WarnContext(ctx, "Failed to get external IP", "error", err)
+		if !options.Force || knownIP == nil {
+			return RefreshResult{}, fmt.Errorf("%w: %w", ErrIPUnavailable, err)
+		}
+		// Forced updates go on with the last known address.
+		currentIP = knownIP
+	} else {
+		r.detected(currentIP)
+	}
+
+	result := RefreshResult{IP: currentIP.IP}
+
+	switch {
+	case knownIP == nil:
+		// The address is detected for the first time, there is no change to submit to hooks or notify.
+		ip.SetCurrent(currentIP)
+		result.RunResult = r.run(ctx, api.Records.Snapshot(), api.IPLists)
+
+	case knownIP.IP != currentIP.IP:
+		result.IPChanged = true
+		event := hooks.Event{Stage: hooks.StagePreIPChange, OldIP: knownIP.IP, NewIP: currentIP.IP}
+
+		// A vetoed change is not applied, and is submitted to the hooks again on the next cycle.
+		if !api.DryRun {
+			if err := hooks.Run(ctx, api.Hooks.PreIPChange, event); err != nil {
+				slog.WarnContext(ctx, "IP change not applied", "error", err)
+				return result, err
+			}
+		}
+
+		ip.SetCurrent(currentIP)
+		r.ipChanged(knownIP.IP, currentIP.IP)
+		result.RunResult = r.run(ctx, api.Records.Snapshot(), api.IPLists)
+		Notify(ctx, knownIP.IP, currentIP.IP, result.RunResult)
+
+		if !api.DryRun {
+			event.Stage = hooks.StagePostIPChange
+			event.Result = "success"
+			if err := result.Err(); err != nil {
+				event.Result = "failure"
+				event.Error = err.Error()
+			}
+			hooks.Run(ctx, api.Hooks.PostIPChange, event)
+		}
+
+	case options.Force:
+		ipLists := api.IPLists
+		if len(options.Records) > 0 {
+			ipLists = &[]cf.IPList{}
+		}
+		result.RunResult = r.run(ctx, records, ipLists)
+
+	default:
+		// Records and IP Lists that failed during the previous cycles are retried until they succeed,
+		// even though the IP address did not change.
+		failedRecords, failedIPLists := r.retries(records, len(options.Records) > 0)
+		if len(failedRecords) == 0 && len(failedIPLists) == 0 {
+			result.RunResult = api.RunResult{DryRun: api.DryRun, StartedAt: time.Now(), Records: []api.RecordResult{}, IPLists: []api.IPListResult{}}
+			return result, nil
+		}
+
+		slog.InfoContext(ctx, "Retrying failed updates", "records", len(failedRecords), "ipLists", len(failedIPLists))
+		result.RunResult = r.run(ctx, failedRecords, &failedIPLists)
+	}
+
+	return result, nil
+}
+
+// The function runs the Runner over the given records and IP Lists, and keeps track of the failed
+// updates to retry.
+func (r *Refresher) run(ctx context.Context, records []cf.ExtendedCloudflareDNSRecord, ipLists *[]cf.IPList) api.RunResult {
+	slog.DebugContext(ctx, "Starting DNS refresh...")
+
+	result, err := Runner(ctx, records, ipLists)
+	if err != nil {
+		slog.With("currentIp", ip.Current()).ErrorContext(ctx, "Error", "error", err)
+	}
+
+	for _, recordResult := range result.Records {
+		if recordResult.Err != nil {
+			r.failedRecords[recordResult.Record.Key()] = true
+		} else {
+			delete(r.failedRecords, recordResult.Record.Key())
+		}
+	}
+	r.recordResults(result)
+
+	for _, listResult := range result.IPLists {
+		if listResult.Err != nil {
+			r.failedIPLists[ipListKey(listResult.List)] = true
+		} else {
+			delete(r.failedIPLists, ipListKey(listResult.List))
+		}
+	}
+
+	slog.DebugContext(ctx, "DNS refresh completed.", "duration", result.Duration)
+
+	return result
+}
+
+// The function returns the failed records still managed, among the given ones when scoped, and the
+// failed IP Lists when not scoped.
+func (r *Refresher) retries(records []cf.ExtendedCloudflareDNSRecord, scoped bool) ([]cf.ExtendedCloudflareDNSRecord, []cf.IPList) {
+	failedRecords := make([]cf.ExtendedCloudflareDNSRecord, 0)
+	for _, record := range records {
+		if r.failedRecords[record.Key()] {
+			failedRecords = append(failedRecords, record)
+		}
+	}
+
+	failedIPLists := make([]cf.IPList, 0)
+	if !scoped {
+		for _, list := range *api.IPLists {
+			if r.failedIPLists[ipListKey(list)] {
+				failedIPLists = append(failedIPLists, list)
+			}
+		}
+	}
+
+	return failedRecords, failedIPLists
+}
+
+func ipListKey(list cf.IPList) string {
+	return list.AccountID + "/" + list.ListName + "/" + list.ListID
+}
+
+// The function returns the managed records matching the selectors, or every managed record when there
+// is no selector. A selector matching no record is an error wrapping cf.ErrRecordNotFound.
+func SelectRecords(selectors []RecordSelector) ([]cf.ExtendedCloudflareDNSRecord, error) {
+	managed := api.Records.Snapshot()
+	if len(selectors) == 0 {
+		return managed, nil
+	}
+
+	selected := make([]cf.ExtendedCloudflareDNSRecord, 0)
+	seen := map[string]bool{}
+
+	for _, selector := range selectors {
+		zoneName, err := cf.NormalizeZoneName(selector.ZoneName)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", cf.ErrValidation, err)
+		}
+
+		name, err := cf.NormalizeName(selector.Name, zoneName)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", cf.ErrValidation, err)
+		}
+
+		found := false
+		for _, record := range managed {
+			if record.ZoneName != zoneName || record.Record.Name != name || (selector.Type != "" && !strings.EqualFold(string(record.Record.Type), selector.Type)) {
+				continue
+			}
+
+			found = true
+			if !seen[record.Key()] {
+				seen[record.Key()] = true
+				selected = append(selected, record)
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("%w: %s", cf.ErrRecordNotFound, strings.TrimSpace(name+" "+selector.Type))
+		}
+	}
+
+	return selected, nil
+}
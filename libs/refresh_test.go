@@ -0,0 +1,137 @@
+package libs
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	"github.com/wasilak/cloudflare-ddns/libs/api"
+	"github.com/wasilak/cloudflare-ddns/libs/cf"
+	"github.com/wasilak/cloudflare-ddns/libs/cf/cftest"
+	"github.com/wasilak/cloudflare-ddns/libs/ip"
+)
+
+// The function points the api package at a fake Cloudflare API holding the example.com zone and
+// manages an A record for every name, as the daemon does after loading its config. The IP detection
+// services answer address, and no address is known yet. The globals are restored when the test ends.
+func setupDaemon(t *testing.T, address string, names ...string) (*cftest.Server, string, *cftest.IPTransport) {
+	t.Helper()
+
+	fake := cftest.NewServer()
+	t.Cleanup(fake.Close)
+	zone := fake.AddZone("example.com")
+
+	transport := cftest.NewIPTransport(address)
+	cfAPI, dnsProvider, records, ipLists, current := api.CfAPI, api.DNSProvider, api.Records, api.IPLists, ip.Current()
+	http.DefaultTransport = transport
+	ip.SetCurrent(nil)
+	t.Cleanup(func() {
+		http.DefaultTransport = transport.Next
+		api.CfAPI, api.DNSProvider, api.Records, api.IPLists = cfAPI, dnsProvider, records, ipLists
+		ip.SetCurrent(current)
+	})
+
+	api.CfAPI = &cf.CF{}
+	api.CfAPI.Init("key", "user@example.com", fake.BaseURL())
+	api.DNSProvider = api.CfAPI
+	api.IPLists = &[]cf.IPList{}
+
+	managed := make([]cf.ExtendedCloudflareDNSRecord, 0, len(names))
+	for _, name := range names {
+		managed = append(managed, cf.ExtendedCloudflareDNSRecord{
+			Record:   &dns.RecordResponse{Name: name, Type: "A", TTL: 1, Comment: api.Owner},
+			ZoneName: "example.com",
+			Origin:   cf.OriginConfig,
+		})
+	}
+	api.Records = api.NewRecordStore(managed)
+
+	return fake, zone.ID, transport
+}
+
+// The function returns the action of every record of the result by name.
+func actions(result RefreshResult) map[string]string {
+	actions := map[string]string{}
+	for _, record := range result.Records {
+		actions[record.Record.Record.Name] = record.Action
+	}
+	return actions
+}
+
+func TestRefresherFollowsIPChanges(t *testing.T) {
+	fake, zoneID, transport := setupDaemon(t, "203.0.113.1", "home.example.com")
+	refresher := NewRefresher()
+	ctx := context.Background()
+
+	result, err := refresher.Refresh(ctx, RefreshOptions{})
+	if err != nil {
+		t.Fatalf("first cycle: %v", err)
+	}
+	if got := actions(result)["home.example.com"]; got != api.ActionCreated {
+		t.Errorf("first cycle action %q, want %q", got, api.ActionCreated)
+	}
+
+	// Nothing is submitted while the address stays the same.
+	requests := len(fake.Requests())
+	result, err = refresher.Refresh(ctx, RefreshOptions{})
+	if err != nil {
+		t.Fatalf("unchanged cycle: %v", err)
+	}
+	if len(result.Records) != 0 || len(fake.Requests()) != requests {
+		t.Errorf("unchanged cycle ran %v, sent %v", result.Records, fake.Requests()[requests:])
+	}
+
+	transport.SetAddress("203.0.113.2")
+	result, err = refresher.Refresh(ctx, RefreshOptions{})
+	if err != nil {
+		t.Fatalf("changed cycle: %v", err)
+	}
+	if !result.IPChanged || actions(result)["home.example.com"] != api.ActionUpdated {
+		t.Errorf("changed cycle: IP changed %v, actions %v", result.IPChanged, actions(result))
+	}
+
+	records := fake.Records(zoneID)
+	if len(records) != 1 || records[0].Content != "203.0.113.2" {
+		t.Errorf("records %+v, want home.example.com at 203.0.113.2", records)
+	}
+}
+
+func TestRefresherRetriesFailedUpdates(t *testing.T) {
+	fake, zoneID, transport := setupDaemon(t, "203.0.113.1", "home.example.com")
+	refresher := NewRefresher()
+	ctx := context.Background()
+
+	if _, err := refresher.Refresh(ctx, RefreshOptions{}); err != nil {
+		t.Fatalf("first cycle: %v", err)
+	}
+
+	// The update is rejected once, the record stays managed and is retried by the next cycle even
+	// though the address does not change again.
+	fake.Fail(cftest.Failure{Method: http.MethodPut, PathPrefix: "zones/", Status: http.StatusBadRequest, Code: 1004, Message: "DNS Validation Error", Times: 1})
+	transport.SetAddress("203.0.113.2")
+
+	result, err := refresher.Refresh(ctx, RefreshOptions{})
+	if err != nil {
+		t.Fatalf("changed cycle: %v", err)
+	}
+	if got := actions(result)["home.example.com"]; got != api.ActionFailed {
+		t.Fatalf("changed cycle action %q, want %q", got, api.ActionFailed)
+	}
+	if api.Records.Len() != 1 {
+		t.Fatalf("%d managed records after the failed update, want 1", api.Records.Len())
+	}
+
+	result, err = refresher.Refresh(ctx, RefreshOptions{})
+	if err != nil {
+		t.Fatalf("retry cycle: %v", err)
+	}
+	if got := actions(result)["home.example.com"]; got != api.ActionUpdated {
+		t.Errorf("retry cycle action %q, want %q", got, api.ActionUpdated)
+	}
+
+	records := fake.Records(zoneID)
+	if len(records) != 1 || records[0].Content != "203.0.113.2" {
+		t.Errorf("records %+v, want home.example.com at 203.0.113.2", records)
+	}
+}
@@ -0,0 +1,416 @@
+package rfc2136
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	cfdns "github.com/cloudflare/cloudflare-go/v4/dns"
+	"github.com/miekg/dns"
+	"github.com/wasilak/cloudflare-ddns/libs/cf"
+)
+
+// Config holds the settings of an RFC 2136 capable authoritative server and the TSIG key used to sign
+// updates sent to it.
+type Config struct {
+	Server  string        `mapstructure:"server"`
+	Net     string        `mapstructure:"net"`
+	Timeout time.Duration `mapstructure:"timeout"`
+	TTL     uint32        `mapstructure:"ttl"`
+	TSIG    TSIGConfig    `mapstructure:"tsig"`
+	// Zones lists the zones served by the server, used to assign records without a zone_name.
+	Zones []string `mapstructure:"zones"`
+}
+
+// TSIGConfig describes a TSIG key: its name, base64 encoded secret and algorithm (e.g. hmac-sha256.).
+type TSIGConfig struct {
+	Name      string `mapstructure:"name"`
+	Secret    string `mapstructure:"secret"`
+	Algorithm string `mapstructure:"algorithm"`
+}
+
+// RFC2136 manages records on an authoritative server through RFC 2136 dynamic updates. Zones are
+// identified by their FQDN and records by "name/type/content", since the protocol has no record IDs.
+type RFC2136 struct {
+	Config Config
+	Client *dns.Client
+}
+
+// The function initializes the DNS client used to talk to the configured server.
+func (r *RFC2136) Init(config Config) {
+	if config.TTL == 0 {
+		config.TTL = 300
+	}
+
+	if config.TSIG.Algorithm == "" {
+		config.TSIG.Algorithm = dns.HmacSHA256
+	}
+
+	r.Config = config
+	r.Client = &dns.Client{
+		Net:     config.Net,
+		Timeout: config.Timeout,
+	}
+
+	if config.TSIG.Name != "" {
+		r.Client.TsigSecret = map[string]string{dns.Fqdn(config.TSIG.Name): config.TSIG.Secret}
+	}
+}
+
+// The function checks that the server is authoritative for the zone and returns the zone FQDN.
+func (r *RFC2136) GetZoneID(ctx context.Context, zoneName string) (string, error) {
+	zone := dns.Fqdn(zoneName)
+
+	m := new(dns.Msg)
+	m.SetQuestion(zone, dns.TypeSOA)
+	m.RecursionDesired = false
+
+	in, err := r.exchange(ctx, m, false)
+	if err != nil {
+		slog.With("zoneName", zoneName).ErrorContext(ctx, "Error GetZoneID", "error", err)
+		return "", err
+	}
+
+	for _, rr := range in.Answer {
+		if soa, ok := rr.(*dns.SOA); ok && strings.EqualFold(soa.Hdr.Name, zone) {
+			return zone, nil
+		}
+	}
+
+	slog.With("zoneName", zoneName).ErrorContext(ctx, "Zone not found")
+	return "", fmt.Errorf("%w: %s", cf.ErrZoneNotFound, zoneName)
+}
+
+// The function returns the configured zones, as the protocol offers no way to enumerate them.
+func (r *RFC2136) ListZones(ctx context.Context) ([]string, error) {
+	return r.Config.Zones, nil
+}
+
+// This function lists the records of a zone using a zone transfer (AXFR). The transfer is bounded by
+// ctx and, for each of its messages, by the configured timeout.
+func (r *RFC2136) ListDNSRecords(ctx context.Context, zoneID string) ([]cf.ExtendedCloudflareDNSRecord, error) {
+	m := new(dns.Msg)
+	m.SetAxfr(zoneID)
+	r.sign(m)
+
+	// Transfers have no context of their own, the connection is dialed with ctx and closed once ctx is
+	// done, which interrupts the transfer.
+	dialer := &net.Dialer{Timeout: r.Config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", r.Config.Server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	transfer := &dns.Transfer{
+		Conn:         &dns.Conn{Conn: conn},
+		ReadTimeout:  r.Config.Timeout,
+		WriteTimeout: r.Config.Timeout,
+		TsigSecret:   r.Client.TsigSecret,
+	}
+
+	envelopes, err := transfer.In(m, r.Config.Server)
+	if err != nil {
+		return nil, transferError(ctx, err)
+	}
+
+	records := make([]cf.ExtendedCloudflareDNSRecord, 0)
+
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			return nil, transferError(ctx, envelope.Error)
+		}
+
+		for _, rr := range envelope.RR {
+			if rr.Header().Rrtype == dns.TypeSOA {
+				continue
+			}
+
+			records = append(records, cf.ExtendedCloudflareDNSRecord{
+				Record:   fromRR(rr),
+				ZoneName: strings.TrimSuffix(zoneID, "."),
+			})
+		}
+	}
+
+	return records, nil
+}
+
+// transferError returns the error of ctx when it ended the transfer, and wraps cf.ErrUnauthorized
+// around TSIG failures.
+func transferError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if errors.Is(err, dns.ErrSig) || errors.Is(err, dns.ErrSecret) || errors.Is(err, dns.ErrTime) {
+		return fmt.Errorf("%w: %w", cf.ErrUnauthorized, err)
+	}
+	return err
+}
+
+// This function queries the server for the records with the name and type of the given record. The
+// protocol has no comments, so records are only told apart by their content.
+func (r *RFC2136) GetDNSRecords(ctx context.Context, record cf.ExtendedCloudflareDNSRecord, zoneID string) ([]cf.ExtendedCloudflareDNSRecord, error) {
+	lookup := *record.Record
+	record.Record = &lookup
+	if err := record.Normalize(); err != nil {
+		return nil, fmt.Errorf("%w: %w", cf.ErrValidation, err)
+	}
+
+	recordType := recordType(record)
+
+	qtype, ok := dns.StringToType[recordType]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported record type %q", cf.ErrValidation, recordType)
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(record.Record.Name), qtype)
+	m.RecursionDesired = false
+
+	in, err := r.exchange(ctx, m, false)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]cf.ExtendedCloudflareDNSRecord, 0)
+
+	for _, rr := range in.Answer {
+		if rr.Header().Rrtype == qtype && strings.EqualFold(rr.Header().Name, dns.Fqdn(record.Record.Name)) {
+			records = append(records, cf.ExtendedCloudflareDNSRecord{
+				Record:   fromRR(rr),
+				ZoneName: record.ZoneName,
+				CNAME:    record.CNAME,
+				Template: record.Template,
+				Origin:   record.Origin,
+			})
+		}
+	}
+
+	return records, nil
+}
+
+// The function adds a record to the zone with an UPDATE message.
+func (r *RFC2136) CreateDNSRecord(ctx context.Context, record cf.ExtendedCloudflareDNSRecord, zoneID string) (*cf.ExtendedCloudflareDNSRecord, error) {
+	rr, err := r.toRR(record.Record.Name, recordType(record), record.Record.Content, record.Record.TTL)
+	if err != nil {
+		return nil, err
+	}
+
+	m := new(dns.Msg)
+	m.SetUpdate(zoneID)
+	m.Insert([]dns.RR{rr})
+
+	if _, err := r.exchange(ctx, m, true); err != nil {
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "Record created",
+		slog.String("Name", record.Record.Name),
+		slog.String("Content", record.Record.Content),
+		slog.Int("TTL", int(rr.Header().Ttl)),
+		slog.Bool("Updated", false),
+		slog.Bool("Created", true),
+	)
+
+	return &cf.ExtendedCloudflareDNSRecord{
+		Record:   fromRR(rr),
+		ZoneName: record.ZoneName,
+		CNAME:    record.CNAME,
+		Template: record.Template,
+		Origin:   record.Origin,
+	}, nil
+}
+
+// The function replaces the record identified by recordID with the given one in a single UPDATE
+// message, so the change is applied atomically by the server.
+func (r *RFC2136) UpdateDNSRecord(ctx context.Context, recordID string, record cf.ExtendedCloudflareDNSRecord, zoneID string) (*cf.ExtendedCloudflareDNSRecord, error) {
+	name, oldType, oldContent, err := parseRecordID(recordID)
+	if err != nil {
+		return nil, err
+	}
+
+	oldRR, err := r.toRR(name, oldType, oldContent, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	rr, err := r.toRR(record.Record.Name, recordType(record), record.Record.Content, record.Record.TTL)
+	if err != nil {
+		return nil, err
+	}
+
+	m := new(dns.Msg)
+	m.SetUpdate(zoneID)
+	m.Remove([]dns.RR{oldRR})
+	m.Insert([]dns.RR{rr})
+
+	if _, err := r.exchange(ctx, m, true); err != nil {
+		slog.With("record", record).ErrorContext(ctx, "UpdateDNSRecord error", "err", err)
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "Record updated",
+		slog.String("Name", record.Record.Name),
+		slog.String("Content", record.Record.Content),
+		slog.Int("TTL", int(rr.Header().Ttl)),
+		slog.Bool("Updated", true),
+		slog.Bool("Created", false),
+	)
+
+	return &cf.ExtendedCloudflareDNSRecord{
+		Record:   fromRR(rr),
+		ZoneName: record.ZoneName,
+		CNAME:    record.CNAME,
+		Template: record.Template,
+		Origin:   record.Origin,
+	}, nil
+}
+
+// The function removes a record from the zone. Without content, the whole RRset is removed.
+func (r *RFC2136) DeleteDNSRecord(ctx context.Context, record cf.ExtendedCloudflareDNSRecord, zoneID string) error {
+	m := new(dns.Msg)
+	m.SetUpdate(zoneID)
+
+	if record.Record.Content == "" {
+		rr, err := dns.NewRR(fmt.Sprintf("%s 0 IN %s", dns.Fqdn(record.Record.Name), recordType(record)))
+		if err != nil {
+			return err
+		}
+		m.RemoveRRset([]dns.RR{rr})
+	} else {
+		rr, err := r.toRR(record.Record.Name, recordType(record), record.Record.Content, 0)
+		if err != nil {
+			return err
+		}
+		m.Remove([]dns.RR{rr})
+	}
+
+	if _, err := r.exchange(ctx, m, true); err != nil {
+		slog.With("record", record).ErrorContext(ctx, "DeleteDNSRecord error", "msg", err)
+		return err
+	}
+
+	slog.InfoContext(ctx, "Record deleted",
+		slog.String("Name", record.Record.Name),
+		slog.String("Content", record.Record.Content),
+	)
+	return nil
+}
+
+// exchange sends the message to the server, signing it when requested and a TSIG key is configured,
+// and turns non-successful response codes and TSIG failures into errors wrapping the cf ones.
+func (r *RFC2136) exchange(ctx context.Context, m *dns.Msg, sign bool) (*dns.Msg, error) {
+	if sign {
+		r.sign(m)
+	}
+
+	in, _, err := r.Client.ExchangeContext(ctx, m, r.Config.Server)
+	if err != nil {
+		if errors.Is(err, dns.ErrSig) || errors.Is(err, dns.ErrSecret) || errors.Is(err, dns.ErrTime) {
+			return nil, fmt.Errorf("%w: %w", cf.ErrUnauthorized, err)
+		}
+		return nil, err
+	}
+
+	if in.Rcode != dns.RcodeSuccess && in.Rcode != dns.RcodeNameError {
+		err := fmt.Errorf("rfc2136: %s returned %s", r.Config.Server, dns.RcodeToString[in.Rcode])
+
+		switch in.Rcode {
+		case dns.RcodeNotAuth, dns.RcodeRefused, dns.RcodeBadSig, dns.RcodeBadKey, dns.RcodeBadTime:
+			return nil, fmt.Errorf("%w: %w", cf.ErrUnauthorized, err)
+		case dns.RcodeNotZone:
+			return nil, fmt.Errorf("%w: %w", cf.ErrZoneNotFound, err)
+		case dns.RcodeNXRrset:
+			return nil, fmt.Errorf("%w: %w", cf.ErrRecordNotFound, err)
+		case dns.RcodeFormatError:
+			return nil, fmt.Errorf("%w: %w", cf.ErrValidation, err)
+		}
+
+		return nil, err
+	}
+
+	return in, nil
+}
+
+func (r *RFC2136) sign(m *dns.Msg) {
+	if r.Config.TSIG.Name != "" {
+		m.SetTsig(dns.Fqdn(r.Config.TSIG.Name), dns.Fqdn(r.Config.TSIG.Algorithm), 300, time.Now().Unix())
+	}
+}
+
+// toRR builds a resource record from Cloudflare style fields. A TTL of 0 or 1 (Cloudflare's
+// "automatic") is replaced with the configured default.
+func (r *RFC2136) toRR(name, recordType, content string, ttl cfdns.TTL) (dns.RR, error) {
+	recordTTL := uint32(ttl)
+	if recordTTL <= 1 {
+		recordTTL = r.Config.TTL
+	}
+
+	switch recordType {
+	case "CNAME", "NS", "PTR":
+		content = dns.Fqdn(content)
+	case "TXT":
+		if !strings.HasPrefix(content, `"`) {
+			content = strconv.Quote(content)
+		}
+	}
+
+	return dns.NewRR(fmt.Sprintf("%s %d IN %s %s", dns.Fqdn(name), recordTTL, recordType, content))
+}
+
+// fromRR converts a resource record to the record representation used across the application.
+func fromRR(rr dns.RR) *cfdns.RecordResponse {
+	header := rr.Header()
+
+	var content string
+	switch v := rr.(type) {
+	case *dns.A:
+		content = v.A.String()
+	case *dns.AAAA:
+		content = v.AAAA.String()
+	case *dns.CNAME:
+		content = strings.TrimSuffix(v.Target, ".")
+	case *dns.TXT:
+		content = strings.Join(v.Txt, "")
+	default:
+		content = strings.TrimSpace(strings.TrimPrefix(rr.String(), header.String()))
+	}
+
+	name := strings.TrimSuffix(header.Name, ".")
+	recordType := dns.TypeToString[header.Rrtype]
+
+	return &cfdns.RecordResponse{
+		ID:      recordID(name, recordType, content),
+		Name:    name,
+		Type:    cfdns.RecordResponseType(recordType),
+		Content: content,
+		TTL:     cfdns.TTL(header.Ttl),
+	}
+}
+
+func recordID(name, recordType, content string) string {
+	return name + "/" + recordType + "/" + content
+}
+
+func parseRecordID(id string) (string, string, string, error) {
+	parts := strings.SplitN(id, "/", 3)
+	if len(parts) != 3 {
+		return "", "", "", fmt.Errorf("invalid record ID %q", id)
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+func recordType(record cf.ExtendedCloudflareDNSRecord) string {
+	if record.Record.Type == "" {
+		return "A"
+	}
+	return string(record.Record.Type)
+}
@@ -0,0 +1,295 @@
+package rfc2136
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cfdns "github.com/cloudflare/cloudflare-go/v4/dns"
+	"github.com/miekg/dns"
+	"github.com/wasilak/cloudflare-ddns/libs/cf"
+)
+
+const (
+	testZone   = "example.com."
+	testKey    = "ddns-key."
+	testSecret = "c2VjcmV0LXNoYXJlZC13aXRoLXRoZS1zZXJ2ZXItZm9yLXRlc3Rz"
+)
+
+// authServer is a minimal authoritative server for testZone, answering queries and zone transfers
+// and applying the dynamic updates signed with testKey.
+type authServer struct {
+	addr string
+
+	mu      sync.Mutex
+	records []dns.RR
+}
+
+// The function starts an authoritative server on a local UDP and TCP port, stopped when the test ends.
+func startAuthServer(t *testing.T) *authServer {
+	t.Helper()
+
+	s := &authServer{}
+
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.addr = packetConn.LocalAddr().String()
+
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		packetConn.Close()
+		t.Fatal(err)
+	}
+
+	secrets := map[string]string{testKey: testSecret}
+	// The default filter refuses UPDATE messages.
+	accept := func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept }
+	for _, server := range []*dns.Server{
+		{PacketConn: packetConn, Handler: s, TsigSecret: secrets, MsgAcceptFunc: accept},
+		{Listener: listener, Handler: s, TsigSecret: secrets, MsgAcceptFunc: accept},
+	} {
+		started := make(chan struct{})
+		server.NotifyStartedFunc = func() { close(started) }
+		go server.ActivateAndServe()
+		<-started
+		t.Cleanup(func() { server.Shutdown() })
+	}
+
+	return s
+}
+
+func (s *authServer) soa() dns.RR {
+	rr, _ := dns.NewRR(testZone + " 3600 IN SOA ns.example.com. hostmaster.example.com. 1 7200 3600 1209600 300")
+	return rr
+}
+
+func (s *authServer) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Authoritative = true
+
+	signed := req.IsTsig() != nil && w.TsigStatus() == nil
+
+	s.mu.Lock()
+	switch {
+	case req.Opcode == dns.OpcodeUpdate && !signed:
+		m.Rcode = dns.RcodeNotAuth
+	case req.Opcode == dns.OpcodeUpdate:
+		s.update(req.Ns)
+	case req.Question[0].Qtype == dns.TypeSOA:
+		m.Answer = []dns.RR{s.soa()}
+	case req.Question[0].Qtype == dns.TypeAXFR && !signed:
+		m.Rcode = dns.RcodeRefused
+	case req.Question[0].Qtype == dns.TypeAXFR:
+		m.Answer = append(append([]dns.RR{s.soa()}, s.records...), s.soa())
+	default:
+		question := req.Question[0]
+		for _, rr := range s.records {
+			if strings.EqualFold(rr.Header().Name, question.Name) && rr.Header().Rrtype == question.Qtype {
+				m.Answer = append(m.Answer, dns.Copy(rr))
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	if signed {
+		m.SetTsig(testKey, dns.HmacSHA256, 300, time.Now().Unix())
+	}
+	w.WriteMsg(m)
+}
+
+// The function applies the update section of an UPDATE message (RFC 2136, section 3.4.2).
+func (s *authServer) update(updates []dns.RR) {
+	for _, update := range updates {
+		header := update.Header()
+
+		switch header.Class {
+		case dns.ClassANY:
+			s.remove(func(rr dns.RR) bool {
+				return strings.EqualFold(rr.Header().Name, header.Name) && rr.Header().Rrtype == header.Rrtype
+			})
+		case dns.ClassNONE:
+			target := dns.Copy(update)
+			target.Header().Class = dns.ClassINET
+			s.remove(func(rr dns.RR) bool { return dns.IsDuplicate(rr, target) })
+		default:
+			s.records = append(s.records, dns.Copy(update))
+		}
+	}
+}
+
+func (s *authServer) remove(matches func(dns.RR) bool) {
+	kept := s.records[:0]
+	for _, rr := range s.records {
+		if !matches(rr) {
+			kept = append(kept, rr)
+		}
+	}
+	s.records = kept
+}
+
+func (s *authServer) contents(name string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	contents := []string{}
+	for _, rr := range s.records {
+		if strings.EqualFold(rr.Header().Name, dns.Fqdn(name)) {
+			contents = append(contents, fromRR(rr).Content)
+		}
+	}
+	return contents
+}
+
+func newProvider(server, secret string, timeout time.Duration) *RFC2136 {
+	provider := &RFC2136{}
+	provider.Init(Config{
+		Server:  server,
+		Net:     "udp",
+		Timeout: timeout,
+		TSIG:    TSIGConfig{Name: testKey, Secret: secret, Algorithm: dns.HmacSHA256},
+	})
+	return provider
+}
+
+func aRecord(name, content string) cf.ExtendedCloudflareDNSRecord {
+	return cf.ExtendedCloudflareDNSRecord{
+		Record:   &cfdns.RecordResponse{Name: name, Type: "A", Content: content, TTL: 1},
+		ZoneName: "example.com",
+	}
+}
+
+func TestCreateUpdateDelete(t *testing.T) {
+	server := startAuthServer(t)
+	provider := newProvider(server.addr, testSecret, 2*time.Second)
+	ctx := context.Background()
+
+	zoneID, err := provider.GetZoneID(ctx, "example.com")
+	if err != nil || zoneID != testZone {
+		t.Fatalf("GetZoneID: %q, %v", zoneID, err)
+	}
+
+	if _, err := provider.CreateDNSRecord(ctx, aRecord("home.example.com", "203.0.113.1"), zoneID); err != nil {
+		t.Fatalf("CreateDNSRecord: %v", err)
+	}
+	if got := server.contents("home.example.com"); len(got) != 1 || got[0] != "203.0.113.1" {
+		t.Fatalf("after create: %v", got)
+	}
+
+	found, err := provider.GetDNSRecords(ctx, aRecord("home.example.com", ""), zoneID)
+	if err != nil || len(found) != 1 {
+		t.Fatalf("GetDNSRecords: %v, %v", found, err)
+	}
+	if found[0].Record.TTL != 300 {
+		t.Errorf("TTL %v, want the default 300", found[0].Record.TTL)
+	}
+
+	if _, err := provider.UpdateDNSRecord(ctx, found[0].Record.ID, aRecord("home.example.com", "203.0.113.2"), zoneID); err != nil {
+		t.Fatalf("UpdateDNSRecord: %v", err)
+	}
+	if got := server.contents("home.example.com"); len(got) != 1 || got[0] != "203.0.113.2" {
+		t.Fatalf("after update: %v", got)
+	}
+
+	listed, err := provider.ListDNSRecords(ctx, zoneID)
+	if err != nil {
+		t.Fatalf("ListDNSRecords: %v", err)
+	}
+	if len(listed) != 1 || listed[0].Record.Name != "home.example.com" || listed[0].Record.Content != "203.0.113.2" {
+		t.Errorf("ListDNSRecords: %+v", listed)
+	}
+
+	if err := provider.DeleteDNSRecord(ctx, aRecord("home.example.com", "203.0.113.2"), zoneID); err != nil {
+		t.Fatalf("DeleteDNSRecord: %v", err)
+	}
+	if got := server.contents("home.example.com"); len(got) != 0 {
+		t.Fatalf("after delete: %v", got)
+	}
+}
+
+func TestBadKeyIsUnauthorized(t *testing.T) {
+	server := startAuthServer(t)
+	provider := newProvider(server.addr, "d3Jvbmctc2VjcmV0", 2*time.Second)
+	ctx := context.Background()
+
+	_, err := provider.CreateDNSRecord(ctx, aRecord("home.example.com", "203.0.113.1"), testZone)
+	if !errors.Is(err, cf.ErrUnauthorized) {
+		t.Errorf("CreateDNSRecord: %v, want an error wrapping ErrUnauthorized", err)
+	}
+	if got := server.contents("home.example.com"); len(got) != 0 {
+		t.Errorf("record created with a bad key: %v", got)
+	}
+
+	if _, err := provider.ListDNSRecords(ctx, testZone); err == nil {
+		t.Error("ListDNSRecords succeeded with a bad key")
+	}
+}
+
+// The function starts a TCP server accepting connections without ever answering.
+func startSilentServer(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		listener.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestListDNSRecordsHonoursContext(t *testing.T) {
+	provider := newProvider(startSilentServer(t), testSecret, time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	_, err := provider.ListDNSRecords(ctx, testZone)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ListDNSRecords: %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("ListDNSRecords returned after %v", elapsed)
+	}
+}
+
+func TestListDNSRecordsHonoursTimeout(t *testing.T) {
+	provider := newProvider(startSilentServer(t), testSecret, 100*time.Millisecond)
+
+	started := time.Now()
+	if _, err := provider.ListDNSRecords(context.Background(), testZone); err == nil {
+		t.Error("ListDNSRecords succeeded without an answer")
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("ListDNSRecords returned after %v", elapsed)
+	}
+}
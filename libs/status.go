@@ -0,0 +1,247 @@
+package libs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/wasilak/cloudflare-ddns/libs/api"
+	"github.com/wasilak/cloudflare-ddns/libs/events"
+	"github.com/wasilak/cloudflare-ddns/libs/ip"
+)
+
+// Bounds of the errors and changes kept in Status.
+const (
+	maxRecentErrors = 20
+	maxHistory      = 100
+)
+
+// Kinds of HistoryEntry.
+const (
+	HistoryIPChange     = "ip_change"
+	HistoryRecordChange = "record_change"
+)
+
+// Status describes the state of the refresh cycles, see Refresher.Status.
+type Status struct {
+	IP         string `json:"ip"`
+	IPSource   string `json:"ip_source"`
+	PreviousIP string `json:"previous_ip,omitempty"`
+	// LastDetection is the last time the external IP was detected, whether it changed or not.
+	LastDetection *time.Time `json:"last_detection,omitempty"`
+	// LastCycle and LastSuccess are the end of the last cycle and of the last one without error.
+	LastCycle   *time.Time `json:"last_cycle,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	NextRun     *time.Time `json:"next_run,omitempty"`
+	Running     bool       `json:"running"`
+	Cycles      int        `json:"cycles"`
+	// ConsecutiveFailures counts the cycles that failed since the last one without error.
+	ConsecutiveFailures int            `json:"consecutive_failures"`
+	Records             []RecordStatus `json:"records"`
+	RecentErrors        []StatusError  `json:"recent_errors"`
+	// History lists the latest IP changes and record changes made by the cycles, oldest first.
+	History []HistoryEntry `json:"history"`
+}
+
+// RecordStatus is the state of a managed record: its current content and the outcome of its last
+// update.
+type RecordStatus struct {
+	ZoneName    string     `json:"zone_name"`
+	Name        string     `json:"name"`
+	Type        string     `json:"type"`
+	Content     string     `json:"content"`
+	Origin      string     `json:"origin,omitempty"`
+	LastAction  string     `json:"last_action,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastRun     *time.Time `json:"last_run,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+}
+
+// StatusError is an error of a recent cycle. Record and ZoneName are set for errors of a single
+// record.
+type StatusError struct {
+	Time     time.Time `json:"time"`
+	Record   string    `json:"record,omitempty"`
+	ZoneName string    `json:"zone_name,omitempty"`
+	Message  string    `json:"message"`
+}
+
+// HistoryEntry is a change of the external IP, or of a record made by a cycle.
+type HistoryEntry struct {
+	Time       time.Time `json:"time"`
+	Kind       string    `json:"kind"`
+	ZoneName   string    `json:"zone_name,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	Type       string    `json:"type,omitempty"`
+	Action     string    `json:"action,omitempty"`
+	OldContent string    `json:"old_content,omitempty"`
+	NewContent string    `json:"new_content"`
+}
+
+// refresherStatus is the state tracked by the Refresher for Status.
+type refresherStatus struct {
+	ip                  *ip.IP
+	previousIP          string
+	lastDetection       time.Time
+	lastCycle           time.Time
+	lastSuccess         time.Time
+	nextRun             time.Time
+	cycles              int
+	consecutiveFailures int
+	records             map[string]RecordStatus
+	recentErrors        []StatusError
+	history             []HistoryEntry
+}
+
+// The function returns the state of the refresh cycles and of the managed records.
+func (r *Refresher) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := Status{
+		PreviousIP:          r.status.previousIP,
+		LastDetection:       timePointer(r.status.lastDetection),
+		LastCycle:           timePointer(r.status.lastCycle),
+		LastSuccess:         timePointer(r.status.lastSuccess),
+		NextRun:             timePointer(r.status.nextRun),
+		Running:             r.running != nil,
+		Cycles:              r.status.cycles,
+		ConsecutiveFailures: r.status.consecutiveFailures,
+		Records:             make([]RecordStatus, 0),
+		RecentErrors:        append([]StatusError{}, r.status.recentErrors...),
+		History:             append([]HistoryEntry{}, r.status.history...),
+	}
+
+	if r.status.ip != nil {
+		status.IP = r.status.ip.IP
+		if r.status.ip.Source != nil {
+			status.IPSource = r.status.ip.Source.GetName()
+		}
+	}
+
+	for _, record := range api.Records.Snapshot() {
+		recordStatus := r.status.records[record.Key()]
+		recordStatus.ZoneName = record.ZoneName
+		recordStatus.Name = record.Record.Name
+		recordStatus.Type = string(record.Record.Type)
+		recordStatus.Content = record.Record.Content
+		recordStatus.Origin = record.Origin
+		status.Records = append(status.Records, recordStatus)
+	}
+
+	return status
+}
+
+// The function sets the time of the next scheduled cycle.
+func (r *Refresher) SetNextRun(next time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.status.nextRun = next
+}
+
+// The function records the detected external IP.
+func (r *Refresher) detected(current *ip.IP) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.status.ip = current
+	r.status.lastDetection = time.Now()
+
+	event := events.Event{Type: events.TypeIPDetected, Time: r.status.lastDetection, IP: current.IP}
+	if current.Source != nil {
+		event.Source = current.Source.GetName()
+	}
+	events.Publish(event)
+}
+
+// The function records a change of the external IP applied by a cycle.
+func (r *Refresher) ipChanged(oldIP, newIP string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.status.previousIP = oldIP
+	r.addHistory(HistoryEntry{Time: time.Now(), Kind: HistoryIPChange, OldContent: oldIP, NewContent: newIP})
+}
+
+// The function records the outcome of every record of a run.
+func (r *Refresher) recordResults(result api.RunResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, recordResult := range result.Records {
+		key := recordResult.Record.Key()
+		recordStatus := r.status.records[key]
+		recordStatus.LastAction = recordResult.Action
+		recordStatus.LastError = recordResult.Error
+		recordStatus.LastRun = &now
+		if recordResult.Err == nil {
+			recordStatus.LastSuccess = &now
+			if !result.DryRun && (recordResult.Action == api.ActionCreated || recordResult.Action == api.ActionUpdated) {
+				r.addHistory(HistoryEntry{
+					Time:       now,
+					Kind:       HistoryRecordChange,
+					ZoneName:   recordResult.Record.ZoneName,
+					Name:       recordResult.Record.Record.Name,
+					Type:       string(recordResult.Record.Record.Type),
+					Action:     recordResult.Action,
+					OldContent: recordResult.OldContent,
+					NewContent: recordResult.NewContent,
+				})
+			}
+		} else {
+			r.addError(StatusError{Time: now, Record: recordResult.Record.Record.Name, ZoneName: recordResult.Record.ZoneName, Message: recordResult.Error})
+		}
+		r.status.records[key] = recordStatus
+	}
+
+	for _, listResult := range result.IPLists {
+		if listResult.Err != nil {
+			r.addError(StatusError{Time: now, Message: fmt.Sprintf("ip list %s: %s", listResult.List.ListName, listResult.Error)})
+		}
+	}
+}
+
+// The function records the end of a cycle. The caller holds mu.
+func (r *Refresher) finished(result RefreshResult, err error) {
+	now := time.Now()
+	r.status.cycles++
+	r.status.lastCycle = now
+
+	if err == nil {
+		err = result.Err()
+	} else {
+		r.addError(StatusError{Time: now, Message: err.Error()})
+	}
+
+	if err != nil {
+		r.status.consecutiveFailures++
+		return
+	}
+
+	r.status.consecutiveFailures = 0
+	r.status.lastSuccess = now
+}
+
+// The function keeps the error among the recent ones. The caller holds mu.
+func (r *Refresher) addError(statusError StatusError) {
+	r.status.recentErrors = append(r.status.recentErrors, statusError)
+	if len(r.status.recentErrors) > maxRecentErrors {
+		r.status.recentErrors = r.status.recentErrors[len(r.status.recentErrors)-maxRecentErrors:]
+	}
+}
+
+// The function keeps the change in the history. The caller holds mu.
+func (r *Refresher) addHistory(entry HistoryEntry) {
+	r.status.history = append(r.status.history, entry)
+	if len(r.status.history) > maxHistory {
+		r.status.history = r.status.history[len(r.status.history)-maxHistory:]
+	}
+}
+
+func timePointer(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
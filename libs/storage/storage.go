@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/wasilak/cloudflare-ddns/libs/cf"
+	bolt "go.etcd.io/bbolt"
+)
+
+// recordsBucket is the bucket holding the records, keyed by cf.ExtendedCloudflareDNSRecord.Key.
+var recordsBucket = []byte("records")
+
+// pinsBucket is the bucket holding the contents pinned by DynDNS updates, keyed like the records.
+var pinsBucket = []byte("pins")
+
+// openTimeout bounds the wait for the file lock held by another running instance.
+const openTimeout = 5 * time.Second
+
+// ErrLocked is returned when the database is held by another process, usually a running daemon. bbolt
+// locks the file for as long as it is open, readers included.
+var ErrLocked = errors.New("storage is locked by another process")
+
+// Storage persists the records created through the REST API, and the contents pinned by DynDNS
+// updates, in an embedded bbolt database, so they survive restarts and keep being maintained.
+type Storage struct {
+	db *bolt.DB
+}
+
+// Options tune how the database is opened.
+type Options struct {
+	// ReadOnly opens an existing database for reading only: nothing is created and writes fail.
+	ReadOnly bool
+	// Timeout bounds the wait for the file lock, openTimeout when zero.
+	Timeout time.Duration
+}
+
+// The function opens the database at path, creating it if needed unless it is opened read-only. A
+// database locked by another process for longer than the timeout is an error wrapping ErrLocked.
+func Open(path string, options Options) (*Storage, error) {
+	if options.Timeout == 0 {
+		options.Timeout = openTimeout
+	}
+
+	if !options.ReadOnly {
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: options.Timeout, ReadOnly: options.ReadOnly})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("opening storage %s: %w", path, ErrLocked)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening storage %s: %w", path, err)
+	}
+
+	if options.ReadOnly {
+		return &Storage{db: db}, nil
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{recordsBucket, pinsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Storage{db: db}, nil
+}
+
+// The function returns every stored record, ordered by key.
+func (s *Storage) List() ([]cf.ExtendedCloudflareDNSRecord, error) {
+	records := make([]cf.ExtendedCloudflareDNSRecord, 0)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(recordsBucket)
+		if bucket == nil {
+			// The bucket is created by read-write opens only.
+			return nil
+		}
+
+		return bucket.ForEach(func(key, value []byte) error {
+			var record cf.ExtendedCloudflareDNSRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return fmt.Errorf("decoding stored record %s: %w", key, err)
+			}
+			record.Origin = cf.OriginAPI
+			records = append(records, record)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// The function stores the record, replacing the one with the same key if any.
+func (s *Storage) Put(record cf.ExtendedCloudflareDNSRecord) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(recordsBucket).Put([]byte(record.Key()), value)
+	})
+}
+
+// The function removes the record with the given key. Removing a missing record is not an error.
+func (s *Storage) Delete(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(recordsBucket).Delete([]byte(key))
+	})
+}
+
+// The function returns every stored pin: the pinned content by record key.
+func (s *Storage) Pins() (map[string]string, error) {
+	pins := map[string]string{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(pinsBucket)
+		if bucket == nil {
+			// The bucket is created by read-write opens only.
+			return nil
+		}
+
+		return bucket.ForEach(func(key, value []byte) error {
+			pins[string(key)] = string(value)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pins, nil
+}
+
+// The function stores the content pinned for the record with the given key.
+func (s *Storage) PutPin(key, content string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(pinsBucket).Put([]byte(key), []byte(content))
+	})
+}
+
+// The function removes the pin of the record with the given key. Removing a missing pin is not an
+// error.
+func (s *Storage) DeletePin(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(pinsBucket).Delete([]byte(key))
+	})
+}
+
+// The function closes the database, releasing its file lock.
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,166 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	"github.com/spf13/viper"
+	"github.com/wasilak/cloudflare-ddns/libs"
+	"github.com/wasilak/cloudflare-ddns/libs/api"
+	"github.com/wasilak/cloudflare-ddns/libs/cf"
+	"github.com/wasilak/cloudflare-ddns/libs/storage"
+)
+
+// The function opens a new database in a temporary directory, closed when the test ends.
+func openTestStorage(t *testing.T) (*storage.Storage, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "records.db")
+	store, err := storage.Open(path, storage.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store, path
+}
+
+// The function returns an A record of example.com with the given TTL.
+func aRecord(name string, ttl float64) cf.ExtendedCloudflareDNSRecord {
+	return cf.ExtendedCloudflareDNSRecord{
+		Record:   &dns.RecordResponse{Name: name, Type: "A", TTL: dns.TTL(ttl), Comment: "cloudflare-ddns"},
+		ZoneName: "example.com",
+	}
+}
+
+func TestStoredRecordsComeFromTheAPI(t *testing.T) {
+	store, _ := openTestStorage(t)
+
+	record := aRecord("home.example.com", 300)
+	record.Origin = cf.OriginConfig
+	if err := store.Put(record); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Record.Name != "home.example.com" {
+		t.Fatalf("records %+v, want home.example.com", records)
+	}
+	if records[0].Origin != cf.OriginAPI {
+		t.Errorf("origin %q, want %q", records[0].Origin, cf.OriginAPI)
+	}
+
+	if err := store.Delete(record.Key()); err != nil {
+		t.Fatal(err)
+	}
+	if records, err := store.List(); err != nil || len(records) != 0 {
+		t.Errorf("records %+v, %v after the deletion", records, err)
+	}
+}
+
+func TestPins(t *testing.T) {
+	store, _ := openTestStorage(t)
+
+	if err := store.PutPin("example.com/home.example.com/A/cloudflare-ddns", "198.51.100.7"); err != nil {
+		t.Fatal(err)
+	}
+	if pins, err := store.Pins(); err != nil || pins["example.com/home.example.com/A/cloudflare-ddns"] != "198.51.100.7" {
+		t.Errorf("pins %v, %v", pins, err)
+	}
+
+	if err := store.DeletePin("example.com/home.example.com/A/cloudflare-ddns"); err != nil {
+		t.Fatal(err)
+	}
+	if pins, err := store.Pins(); err != nil || len(pins) != 0 {
+		t.Errorf("pins %v, %v after the deletion", pins, err)
+	}
+}
+
+// The configuration takes precedence over a stored record with the same key, the other stored records
+// being added to the configured ones.
+func TestConfigTakesPrecedenceOverStoredRecords(t *testing.T) {
+	store, _ := openTestStorage(t)
+
+	for _, record := range []cf.ExtendedCloudflareDNSRecord{aRecord("home.example.com", 600), aRecord("api.example.com", 600)} {
+		if err := store.Put(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	previous := api.Storage
+	api.Storage = store
+	t.Cleanup(func() {
+		api.Storage = previous
+		api.SetRecordHooks(nil)
+		viper.Reset()
+	})
+
+	viper.Reset()
+	viper.Set("records", []map[string]any{{
+		"zone_name": "example.com",
+		"record":    map[string]any{"name": "home.example.com", "type": "A", "ttl": 300},
+	}})
+
+	records, err := libs.PrepareRecords(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]cf.ExtendedCloudflareDNSRecord{}
+	for _, record := range *records {
+		got[record.Record.Name] = record
+	}
+
+	if home := got["home.example.com"]; home.Origin != cf.OriginConfig || home.Record.TTL != 300 {
+		t.Errorf("home.example.com from %q with TTL %v, want the configured one", home.Origin, home.Record.TTL)
+	}
+	if stored := got["api.example.com"]; stored.Origin != cf.OriginAPI {
+		t.Errorf("api.example.com from %q, want %q", stored.Origin, cf.OriginAPI)
+	}
+	if len(got) != 2 {
+		t.Errorf("records %v, want home.example.com and api.example.com", got)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing", "records.db")
+	if _, err := storage.Open(missing, storage.Options{ReadOnly: true}); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("opening a missing database read-only: %v, want an error wrapping fs.ErrNotExist", err)
+	}
+
+	store, path := openTestStorage(t)
+	if err := store.Put(aRecord("home.example.com", 300)); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	readOnly, err := storage.Open(path, storage.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+
+	if records, err := readOnly.List(); err != nil || len(records) != 1 {
+		t.Errorf("records %+v, %v, want home.example.com", records, err)
+	}
+	if err := readOnly.Put(aRecord("api.example.com", 300)); err == nil {
+		t.Error("a read-only storage accepted a record")
+	}
+}
+
+func TestLockedStorage(t *testing.T) {
+	_, path := openTestStorage(t)
+
+	_, err := storage.Open(path, storage.Options{ReadOnly: true, Timeout: 50 * time.Millisecond})
+	if !errors.Is(err, storage.ErrLocked) {
+		t.Errorf("opening a database held by another instance: %v, want an error wrapping ErrLocked", err)
+	}
+}
@@ -3,76 +3,335 @@ package libs
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/spf13/viper"
 	"github.com/wasilak/cloudflare-ddns/libs/api"
 	"github.com/wasilak/cloudflare-ddns/libs/cf"
-	"github.com/wasilak/cloudflare-ddns/libs/ip"
+	"github.com/wasilak/cloudflare-ddns/libs/events"
+	"github.com/wasilak/cloudflare-ddns/libs/hooks"
 )
 
-func PrepareRecords() *[]cf.ExtendedCloudflareDNSRecord {
+// configRecord is a record of the config file, with the hooks run around its update.
+type configRecord struct {
+	cf.ExtendedCloudflareDNSRecord `mapstructure:",squash"`
+	Hooks                          *hooks.RecordHooks `mapstructure:"hooks,omitempty"`
+}
+
+// PrepareRecords loads the records from the environment (when CFDDNS_RECORDS is set) or from the
+// config file, normalizes their names to canonical FQDNs within their zones and validates their
+// settings, returning a *cf.ValidationError listing every invalid record. Records without a zone_name
+// are assigned to a zone discovered through the DNS provider, records without a comment are tagged
+// with the owner. The hooks of the records, only read from the config file, are registered with
+// api.SetRecordHooks. Records created through the REST API are then loaded from the storage, see
+// mergeStoredRecords.
+func PrepareRecords(ctx context.Context) (*[]cf.ExtendedCloudflareDNSRecord, error) {
+	var records *[]cf.ExtendedCloudflareDNSRecord
+	var recordHooks []*hooks.RecordHooks
+	var err error
+
 	_, present := os.LookupEnv(viper.GetEnvPrefix() + "_RECORDS")
 
 	if present {
-		return prepareRecordsFromEnv()
+		records, err = prepareRecordsFromEnv()
+	} else {
+		records, recordHooks, err = prepareRecordsFromConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if records == nil {
+		records = &[]cf.ExtendedCloudflareDNSRecord{}
+	}
+
+	var zoneNames []string
+	validationError := &cf.ValidationError{}
+
+	for i := range *records {
+		record := &(*records)[i]
+
+		// Records without zone_name are assigned to the longest matching zone visible to the provider.
+		if record.ZoneName == "" && record.Record != nil {
+			if zoneNames == nil {
+				zoneNames, err = api.DNSProvider.ListZones(ctx)
+				if err != nil {
+					return nil, fmt.Errorf("listing zones: %w", err)
+				}
+				slog.DebugContext(ctx, "Discovered zones", "zones", zoneNames)
+			}
+
+			record.ZoneName, err = cf.ZoneForName(record.Record.Name, zoneNames)
+			if err != nil {
+				validationError.Errors = append(validationError.Errors, cf.RecordError{
+					Index:   i,
+					Name:    record.Record.Name,
+					Field:   "zone_name",
+					Message: err.Error(),
+				})
+				continue
+			}
+		}
+
+		// Untagged records are tagged with the owner, telling them apart from sibling records.
+		if record.Record != nil && record.Record.Comment == "" {
+			record.Record.Comment = api.Owner
+		}
+
+		for _, recordError := range append(record.Check(api.RecordValidation), api.CheckTemplate(*record)...) {
+			recordError.Index = i
+			validationError.Errors = append(validationError.Errors, recordError)
+		}
+
+		if i < len(recordHooks) && recordHooks[i] != nil {
+			if err := hooks.Validate(recordHooks[i].PreUpdate, recordHooks[i].PostUpdate); err != nil {
+				validationError.Errors = append(validationError.Errors, cf.RecordError{
+					Index:    i,
+					Name:     record.Record.Name,
+					ZoneName: record.ZoneName,
+					Field:    "hooks",
+					Message:  err.Error(),
+				})
+			}
+		}
 	}
 
-	return prepareRecordsFromConfig()
+	if len(validationError.Errors) > 0 {
+		return nil, validationError
+	}
+
+	hooksByKey := map[string]hooks.RecordHooks{}
+	for i := range *records {
+		(*records)[i].Origin = cf.OriginConfig
+		if i < len(recordHooks) && recordHooks[i] != nil {
+			hooksByKey[(*records)[i].Key()] = *recordHooks[i]
+		}
+	}
+	api.SetRecordHooks(hooksByKey)
+
+	if api.Storage != nil {
+		stored, err := api.Storage.List()
+		if err != nil {
+			return nil, fmt.Errorf("loading stored records: %w", err)
+		}
+		*records = mergeStoredRecords(ctx, *records, stored)
+	}
+
+	return records, nil
+}
+
+// mergeStoredRecords appends the records created through the REST API to the configured ones. The
+// configuration takes precedence: a stored record with the same key as a configured one is ignored,
+// as is a stored record no longer passing validation.
+func mergeStoredRecords(ctx context.Context, records []cf.ExtendedCloudflareDNSRecord, stored []cf.ExtendedCloudflareDNSRecord) []cf.ExtendedCloudflareDNSRecord {
+	keys := make(map[string]bool, len(records))
+	for _, record := range records {
+		keys[record.Key()] = true
+	}
+
+	for _, record := range stored {
+		if errs := append(record.Check(api.RecordValidation), api.CheckTemplate(record)...); len(errs) > 0 {
+			slog.With("record", record).WarnContext(ctx, "Ignoring invalid stored record", "errors", errs)
+			continue
+		}
+
+		if keys[record.Key()] {
+			slog.With(api.PrepareRecordForLoggiong("record", &record)).WarnContext(ctx, "Ignoring stored record overridden by the configuration")
+			continue
+		}
+
+		keys[record.Key()] = true
+		records = append(records, record)
+	}
+
+	return records
 }
 
-func prepareRecordsFromEnv() *[]cf.ExtendedCloudflareDNSRecord {
+func prepareRecordsFromEnv() (*[]cf.ExtendedCloudflareDNSRecord, error) {
 	var records *[]cf.ExtendedCloudflareDNSRecord
 
 	byt := []byte(viper.GetString("records"))
 
 	if err := json.Unmarshal(byt, &records); err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	return records
+	return records, nil
 }
 
-func prepareRecordsFromConfig() *[]cf.ExtendedCloudflareDNSRecord {
-	var records *[]cf.ExtendedCloudflareDNSRecord
-	viper.UnmarshalKey("records", &records)
-	return records
+// The function returns the records of the config file and, at the same indexes, their hooks.
+func prepareRecordsFromConfig() (*[]cf.ExtendedCloudflareDNSRecord, []*hooks.RecordHooks, error) {
+	var configRecords []configRecord
+	if err := viper.UnmarshalKey("records", &configRecords); err != nil {
+		return nil, nil, err
+	}
+	if configRecords == nil {
+		return nil, nil, nil
+	}
+
+	records := make([]cf.ExtendedCloudflareDNSRecord, 0, len(configRecords))
+	recordHooks := make([]*hooks.RecordHooks, 0, len(configRecords))
+	for _, record := range configRecords {
+		records = append(records, record.ExtendedCloudflareDNSRecord)
+		recordHooks = append(recordHooks, record.Hooks)
+	}
+
+	return &records, recordHooks, nil
+}
+
+// PrepareIPLists loads the Cloudflare IP Lists to keep up to date from the environment (when
+// CFDDNS_IP_LISTS is set) or from the config file. Entries without a comment are tagged with the app
+// name and hostname, identifying the entry owned by this instance.
+func PrepareIPLists() (*[]cf.IPList, error) {
+	var ipLists *[]cf.IPList
+
+	if _, present := os.LookupEnv(viper.GetEnvPrefix() + "_IP_LISTS"); present {
+		if err := json.Unmarshal([]byte(viper.GetString("ip_lists")), &ipLists); err != nil {
+			return nil, err
+		}
+	} else if err := viper.UnmarshalKey("ip_lists", &ipLists); err != nil {
+		return nil, err
+	}
+
+	if ipLists == nil {
+		ipLists = &[]cf.IPList{}
+	}
+
+	for i := range *ipLists {
+		list := &(*ipLists)[i]
+
+		if list.AccountID == "" {
+			return nil, fmt.Errorf("ip list %d: account_id is required", i)
+		}
+
+		if list.ListName == "" && list.ListID == "" {
+			return nil, fmt.Errorf("ip list %d: list_name or list_id is required", i)
+		}
+
+		if list.Comment == "" {
+			hostname, _ := os.Hostname()
+			list.Comment = GetAppName() + ":" + hostname
+		}
+	}
+
+	return ipLists, nil
 }
 
-// The Runner function updates DNS records for a given IP address using Cloudflare API.
-func Runner(ctx context.Context, records *[]cf.ExtendedCloudflareDNSRecord) error {
+// The Runner function updates DNS records and IP Lists for the current IP address. Records are passed
+// as a snapshot (see api.RecordStore.Snapshot), so each goroutine works on its own copy. It returns
+// the outcome of every record and IP List, also kept as the latest run (see api.LastRun), along with
+// the errors of the failed ones joined together.
+func Runner(ctx context.Context, records []cf.ExtendedCloudflareDNSRecord, ipLists *[]cf.IPList) (api.RunResult, error) {
 	var wg sync.WaitGroup
 
-	for _, record := range *records {
-		wg.Add(1)
+	result := api.RunResult{
+		DryRun:    api.DryRun,
+		StartedAt: time.Now(),
+		Records:   make([]api.RecordResult, len(records)),
+		IPLists:   make([]api.IPListResult, len(*ipLists)),
+	}
 
-		if record.Record.Type == "CNAME" {
-			if record.CNAME == "" {
-				slog.With(api.PrepareRecordForLoggiong("record", &record)).ErrorContext(ctx, "This is CNAME record but CNAME value is empty")
-				continue
-			}
-			record.Record.Content = record.CNAME
-		} else {
-			record.Record.Content = ip.CurrentIp.IP
+	for i, record := range records {
+		if record.Record.Type == "CNAME" && record.CNAME == "" && record.Template == "" {
+			slog.With(api.PrepareRecordForLoggiong("record", &record)).ErrorContext(ctx, "This is CNAME record but CNAME value is empty")
+			result.Records[i] = api.RecordResult{Record: record, Action: api.ActionSkipped}
+			continue
 		}
 
-		go runDNSUpdate(&wg, ctx, record)
+		content, err := api.DesiredContent(record)
+		if err != nil {
+			slog.With(api.PrepareRecordForLoggiong("record", &record)).ErrorContext(ctx, "Error rendering template", "error", err)
+			err = fmt.Errorf("%w: rendering template: %w", cf.ErrValidation, err)
+			result.Records[i] = api.RecordResult{Record: record, Action: api.ActionFailed, Err: err, Error: err.Error()}
+			continue
+		}
+		record.Record.Content = content
+
+		wg.Add(1)
+		go runDNSUpdate(&wg, ctx, record, &result.Records[i])
+	}
+
+	for i, list := range *ipLists {
+		wg.Add(1)
+		go runIPListUpdate(&wg, ctx, list, &result.IPLists[i])
 	}
 
 	wg.Wait()
 
-	return nil
+	result.Duration = time.Since(result.StartedAt)
+	result.DurationMS = result.Duration.Milliseconds()
+
+	api.SetLastRun(result)
+	publishResults(result)
+
+	return result, result.Err()
+}
+
+// The function publishes an event for every record created, updated or failed by the run, and for
+// every IP List that failed.
+func publishResults(result api.RunResult) {
+	for _, recordResult := range result.Records {
+		event := events.Event{
+			ZoneName:   recordResult.Record.ZoneName,
+			Name:       recordResult.Record.Record.Name,
+			RecordType: string(recordResult.Record.Record.Type),
+			OldContent: recordResult.OldContent,
+			NewContent: recordResult.NewContent,
+			Error:      recordResult.Error,
+			DryRun:     result.DryRun,
+		}
+
+		switch {
+		case recordResult.Err != nil:
+			event.Type = events.TypeUpdateFailed
+		case recordResult.Action == api.ActionCreated:
+			event.Type = events.TypeRecordCreated
+		case recordResult.Action == api.ActionUpdated:
+			event.Type = events.TypeRecordUpdated
+		default:
+			continue
+		}
+
+		events.Publish(event)
+	}
+
+	for _, listResult := range result.IPLists {
+		if listResult.Err != nil {
+			events.Publish(events.Event{
+				Type:       events.TypeUpdateFailed,
+				IPList:     listResult.List.ListName,
+				NewContent: listResult.NewContent,
+				Error:      listResult.Error,
+				DryRun:     result.DryRun,
+			})
+		}
+	}
 }
 
 // This function updates a DNS record with a given IP address and record name using the Cloudflare API.
-func runDNSUpdate(wg *sync.WaitGroup, ctx context.Context, record cf.ExtendedCloudflareDNSRecord) {
-	err := api.RunDNSUpdate(ctx, record)
+func runDNSUpdate(wg *sync.WaitGroup, ctx context.Context, record cf.ExtendedCloudflareDNSRecord, result *api.RecordResult) {
+	defer wg.Done()
+
+	var err error
+	*result, err = api.RunDNSUpdate(ctx, record)
 	if err != nil {
 		slog.With(api.PrepareRecordForLoggiong("record", &record)).ErrorContext(ctx, "RunDNSUpdate Error", "error", err)
 	}
-	wg.Done()
+}
+
+// This function updates the entry of an IP List with the current IP address.
+func runIPListUpdate(wg *sync.WaitGroup, ctx context.Context, list cf.IPList, result *api.IPListResult) {
+	defer wg.Done()
+
+	var err error
+	*result, err = api.RunIPListUpdate(ctx, list)
+	if err != nil {
+		slog.With(api.PrepareIPListForLogging("ipList", &list)).ErrorContext(ctx, "RunIPListUpdate Error", "error", err)
+	}
 }
 
 func GetAppName() string {
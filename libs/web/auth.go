@@ -0,0 +1,229 @@
+package web
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/wasilak/cloudflare-ddns/libs/cf"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Scopes granted to credentials. A read-write credential can also read.
+const (
+	ScopeRead      = "read"
+	ScopeReadWrite = "write"
+)
+
+// principalKey is the echo context key holding the authenticated credential.
+const principalKey = "principal"
+
+// AuthConfig configures the authentication of the REST API. Authentication is enabled as soon as a
+// credential is defined.
+type AuthConfig struct {
+	Credentials []Credential `mapstructure:"credentials"`
+	// PublicHealth and PublicMetrics leave /health, /livez and /readyz, and /metrics reachable without
+	// credentials.
+	PublicHealth  bool `mapstructure:"public_health"`
+	PublicMetrics bool `mapstructure:"public_metrics"`
+}
+
+// Credential is a bearer token (no Username) or an HTTP basic user. Hash is the hashed secret:
+// "sha256:<hex>" or a bcrypt hash, see the hash command. Zones, when not empty, restricts the
+// credential to the records of these zones.
+type Credential struct {
+	Name     string   `mapstructure:"name"`
+	Username string   `mapstructure:"username"`
+	Hash     string   `mapstructure:"hash"`
+	Scope    string   `mapstructure:"scope"`
+	Zones    []string `mapstructure:"zones"`
+}
+
+// The function reports whether authentication is enabled.
+func (a AuthConfig) Enabled() bool {
+	return len(a.Credentials) > 0
+}
+
+// The function checks the credentials and normalizes their zones.
+func (a *AuthConfig) Validate() error {
+	for i := range a.Credentials {
+		credential := &a.Credentials[i]
+
+		if credential.Name == "" {
+			credential.Name = credential.Username
+		}
+
+		if !validHash(credential.Hash) {
+			return fmt.Errorf("credential %d (%s): hash must be sha256:<hex> or bcrypt", i, credential.Name)
+		}
+
+		switch credential.Scope {
+		case ScopeRead, ScopeReadWrite:
+		case "":
+			credential.Scope = ScopeRead
+		default:
+			return fmt.Errorf("credential %d (%s): unknown scope %q", i, credential.Name, credential.Scope)
+		}
+
+		for j, zone := range credential.Zones {
+			normalized, err := cf.NormalizeZoneName(zone)
+			if err != nil {
+				return fmt.Errorf("credential %d (%s): %w", i, credential.Name, err)
+			}
+			credential.Zones[j] = normalized
+		}
+	}
+
+	return nil
+}
+
+// The function returns the hash of secret in the format expected in Credential.Hash: bcrypt when
+// useBcrypt is set, SHA-256 otherwise (fine for long random tokens, not for passwords).
+func HashSecret(secret string, useBcrypt bool) (string, error) {
+	if useBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+		return string(hash), err
+	}
+
+	sum := sha256.Sum256([]byte(secret))
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// The function reports whether the credential allows the zone.
+func (c *Credential) AllowsZone(zoneName string) bool {
+	if len(c.Zones) == 0 {
+		return true
+	}
+
+	zoneName, err := cf.NormalizeZoneName(zoneName)
+	if err != nil {
+		return false
+	}
+
+	for _, zone := range c.Zones {
+		if zone == zoneName {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Credential) matches(secret string) bool {
+	return secretMatches(c.Hash, secret)
+}
+
+// The function reports whether the hash has one of the formats returned by HashSecret.
+func validHash(hash string) bool {
+	return strings.HasPrefix(hash, "sha256:") || strings.HasPrefix(hash, "$2")
+}
+
+// The function reports whether secret matches the hash returned by HashSecret.
+func secretMatches(hash, secret string) bool {
+	if strings.HasPrefix(hash, "sha256:") {
+		sum := sha256.Sum256([]byte(secret))
+		expected, err := hex.DecodeString(strings.TrimPrefix(hash, "sha256:"))
+		return err == nil && subtle.ConstantTimeCompare(sum[:], expected) == 1
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret)) == nil
+}
+
+// The function returns the middleware authenticating requests with a bearer token or HTTP basic
+// credentials, and checking the scope: reads need ScopeRead, anything else ScopeReadWrite.
+func authMiddleware(config AuthConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			path := c.Path()
+			probe := path == "/health" || path == "/livez" || path == "/readyz"
+			// The DynDNS endpoint checks its own credentials, see dynDNSUpdate.
+			public := isDashboard(path) || path == dynDNSPath
+			if (probe && config.PublicHealth) || (path == "/metrics" && config.PublicMetrics) || public {
+				return next(c)
+			}
+
+			credential := authenticate(config, c.Request())
+			if credential == nil {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="cloudflare-ddns"`)
+				return c.JSON(http.StatusUnauthorized, ErrorResponse{
+					Code:    CodeUnauthorized,
+					Message: "Unauthorized",
+					Error:   "missing or invalid credentials",
+				})
+			}
+
+			method := c.Request().Method
+			if method != http.MethodGet && method != http.MethodHead && credential.Scope != ScopeReadWrite {
+				return c.JSON(http.StatusForbidden, ErrorResponse{
+					Code:    CodeForbidden,
+					Message: "Forbidden",
+					Error:   "credential is read-only",
+				})
+			}
+
+			c.Set(principalKey, credential)
+			return next(c)
+		}
+	}
+}
+
+// The function reports whether the route serves the dashboard files, which hold no data and must load
+// for the token to be entered.
+func isDashboard(path string) bool {
+	return path == "/" || path == dashboardPath || strings.HasPrefix(path, dashboardPath+"/")
+}
+
+func authenticate(config AuthConfig, r *http.Request) *Credential {
+	if header := r.Header.Get(echo.HeaderAuthorization); strings.HasPrefix(header, "Bearer ") {
+		token := strings.TrimPrefix(header, "Bearer ")
+		for i := range config.Credentials {
+			if credential := &config.Credentials[i]; credential.Username == "" && credential.matches(token) {
+				return credential
+			}
+		}
+		return nil
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil
+	}
+
+	for i := range config.Credentials {
+		if credential := &config.Credentials[i]; credential.Username != "" && credential.Username == username && credential.matches(password) {
+			return credential
+		}
+	}
+
+	return nil
+}
+
+// The function returns the authenticated credential, nil when authentication is disabled.
+func principal(c echo.Context) *Credential {
+	credential, _ := c.Get(principalKey).(*Credential)
+	return credential
+}
+
+// The function reports whether the request may access the records of the zone.
+func allowsZone(c echo.Context, zoneName string) bool {
+	credential := principal(c)
+	return credential == nil || credential.AllowsZone(zoneName)
+}
+
+// The function answers a request for a zone outside of the credential restrictions.
+func forbiddenZone(c echo.Context, zoneName string) error {
+	return c.JSON(http.StatusForbidden, forbiddenZoneResponse(zoneName))
+}
+
+func forbiddenZoneResponse(zoneName string) ErrorResponse {
+	return ErrorResponse{
+		Code:     CodeForbidden,
+		Message:  "Forbidden",
+		Error:    "credential is not allowed to manage this zone",
+		ZoneName: zoneName,
+	}
+}
@@ -2,7 +2,10 @@ package web
 
 import (
 	"log/slog"
+	"net"
 	"sync"
+
+	"github.com/wasilak/cloudflare-ddns/libs"
 )
 
 // HealthResponse type
@@ -14,6 +17,21 @@ type FrameworkOptions struct {
 	ListenAddr     string
 	OtelEnabled    bool
 	LogLevelConfig *slog.LevelVar
+	Auth           AuthConfig
+	// DynDNS enables the DynDNS2 update endpoint for routers, /nic/update.
+	DynDNS DynDNSConfig
+	// TLS serves HTTPS instead of plain HTTP at ListenAddr.
+	TLS TLSConfig
+	// MetricsListenAddr, when set, serves /metrics on a separate plain HTTP listener instead of
+	// ListenAddr.
+	MetricsListenAddr string
+	// Refresher runs the refresh cycles triggered through POST /api/refresh, which answers 503 when nil.
+	Refresher *libs.Refresher
+	// FailureThreshold is the number of consecutive failed cycles after which /readyz fails.
+	FailureThreshold int
+	// TrustedProxies are the networks of the reverse proxies whose X-Forwarded-For header is trusted
+	// for the client address. The address of the connection is used when empty.
+	TrustedProxies []*net.IPNet
 }
 
 type WebServer struct {
@@ -0,0 +1,36 @@
+package web
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// dashboardPath is where the dashboard is served.
+const dashboardPath = "/ui"
+
+// dashboardFiles holds the web dashboard: a static page showing the external IP, the records and
+// their live state in Cloudflare, and the change history, on top of /status and the REST API.
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// The function serves the dashboard under dashboardPath and redirects / to it. The files themselves
+// are public, the API calls they make are authenticated like any other.
+func (s *Server) registerDashboard() {
+	files, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		// The files are embedded, failing to open them is a bug rather than a runtime condition.
+		panic(err)
+	}
+
+	s.Server.StaticFS(dashboardPath+"/", files)
+	s.Server.GET(dashboardPath, redirectToDashboard)
+	s.Server.GET("/", redirectToDashboard)
+}
+
+func redirectToDashboard(c echo.Context) error {
+	return c.Redirect(http.StatusFound, dashboardPath+"/")
+}
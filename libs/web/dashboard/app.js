@@ -0,0 +1,238 @@
+"use strict";
+
+// The dashboard only uses the REST API: /status for the state of the daemon, /api/v1 for the records
+// and /api/refresh for the refresh buttons. Requests carry the bearer token saved in the browser, or
+// else the credentials the browser prompts for.
+
+const tokenKey = "cloudflare-ddns-token";
+
+function $(id) {
+    return document.getElementById(id);
+}
+
+async function request(method, path, body) {
+    const headers = {};
+    const token = localStorage.getItem(tokenKey);
+    if (token) {
+        headers["Authorization"] = "Bearer " + token;
+    }
+    if (body !== undefined) {
+        headers["Content-Type"] = "application/json";
+    }
+
+    const response = await fetch(path, {
+        method: method,
+        headers: headers,
+        body: body === undefined ? undefined : JSON.stringify(body),
+    });
+
+    if (response.status === 204) {
+        return null;
+    }
+
+    const data = await response.json().catch(() => null);
+    if (!response.ok) {
+        const reason = data ? data.message + ": " + data.error : response.statusText;
+        throw new Error(reason);
+    }
+
+    return data;
+}
+
+function showMessage(text, isError) {
+    const message = $("message");
+    message.textContent = text;
+    message.classList.toggle("error", Boolean(isError));
+    message.hidden = false;
+}
+
+function formatTime(value) {
+    return value ? new Date(value).toLocaleString() : "-";
+}
+
+function cell(row, text, className) {
+    const td = document.createElement("td");
+    td.textContent = text === undefined || text === "" ? "-" : text;
+    if (className) {
+        td.className = className;
+    }
+    row.appendChild(td);
+    return td;
+}
+
+function renderStatus(status) {
+    $("ip").textContent = status.ip || "-";
+    $("ip-source").textContent = status.ip_source || "-";
+    $("previous-ip").textContent = status.previous_ip || "-";
+    $("last-detection").textContent = formatTime(status.last_detection);
+    $("last-success").textContent = formatTime(status.last_success);
+    $("next-run").textContent = formatTime(status.next_run);
+    $("failures").textContent = status.consecutive_failures;
+
+    const history = $("history");
+    history.replaceChildren();
+    for (const entry of status.history.slice().reverse()) {
+        const row = document.createElement("tr");
+        cell(row, formatTime(entry.time));
+        if (entry.kind === "ip_change") {
+            cell(row, "IP changed");
+        } else {
+            cell(row, entry.action + " " + entry.name + " " + entry.type);
+        }
+        cell(row, entry.old_content ? entry.old_content + " → " + entry.new_content : entry.new_content);
+        history.appendChild(row);
+    }
+
+    const errors = $("errors");
+    errors.replaceChildren();
+    for (const statusError of status.recent_errors.slice().reverse()) {
+        const item = document.createElement("li");
+        item.textContent = formatTime(statusError.time) + " " + (statusError.record ? statusError.record + ": " : "") + statusError.message;
+        errors.appendChild(item);
+    }
+}
+
+function renderRecords(records, states) {
+    const tbody = $("records");
+    tbody.replaceChildren();
+
+    for (const item of records) {
+        const record = item.record;
+        const state = states.get(item.zone_name + "/" + record.name + "/" + record.type) || {};
+        const row = document.createElement("tr");
+
+        cell(row, record.name);
+        cell(row, record.type);
+        cell(row, record.content);
+
+        if (!item.live) {
+            cell(row, "-");
+        } else if (!item.live.found) {
+            cell(row, "missing", "drifted");
+        } else {
+            cell(row, item.live.content + (item.live.proxied ? " (proxied)" : ""), item.live.in_sync ? "in-sync" : "drifted");
+        }
+
+        const update = state.last_action ? state.last_action + " " + formatTime(state.last_run) : "-";
+        cell(row, state.last_error ? "failed: " + state.last_error : update, state.last_error ? "failed" : "");
+        cell(row, item.origin);
+
+        const actions = cell(row, "");
+        actions.textContent = "";
+        const remove = document.createElement("button");
+        remove.textContent = "Delete";
+        remove.addEventListener("click", () => deleteRecord(item));
+        actions.appendChild(remove);
+
+        tbody.appendChild(row);
+    }
+}
+
+async function load() {
+    try {
+        const status = await request("GET", "/status");
+        renderStatus(status);
+
+        const states = new Map();
+        for (const state of status.records) {
+            states.set(state.zone_name + "/" + state.name + "/" + state.type, state);
+        }
+
+        const zones = await request("GET", "/api/v1/zones?per_page=500");
+        const zoneSelect = $("add-zone");
+        const selected = zoneSelect.value;
+        zoneSelect.replaceChildren();
+
+        const records = [];
+        for (const zone of zones.zones) {
+            const option = document.createElement("option");
+            option.textContent = zone.name;
+            zoneSelect.appendChild(option);
+
+            if (zone.records > 0) {
+                const list = await request("GET", "/api/v1/zones/" + encodeURIComponent(zone.name) + "/records?live=true&per_page=500");
+                records.push(...list.records);
+            }
+        }
+        if (selected) {
+            zoneSelect.value = selected;
+        }
+
+        renderRecords(records, states);
+    } catch (error) {
+        showMessage(error.message, true);
+    }
+}
+
+async function refresh(force) {
+    try {
+        showMessage(force ? "Updating every record..." : "Refreshing...");
+        const result = await request("POST", "/api/refresh", { force: force });
+        const changed = result.records.filter((r) => r.action === "created" || r.action === "updated").length;
+        const failed = result.records.filter((r) => r.error).length;
+        showMessage("Refresh done for IP " + result.ip + ": " + changed + " changed, " + failed + " failed.", failed > 0);
+    } catch (error) {
+        showMessage(error.message, true);
+    }
+    await load();
+}
+
+async function deleteRecord(item) {
+    const record = item.record;
+    if (!confirm("Delete " + record.name + " " + record.type + "?")) {
+        return;
+    }
+
+    try {
+        const path = "/api/v1/zones/" + encodeURIComponent(item.zone_name) + "/records/" + encodeURIComponent(record.name) + "/" + encodeURIComponent(record.type);
+        await request("DELETE", path);
+        showMessage("Deleted " + record.name + " " + record.type + ".");
+    } catch (error) {
+        showMessage(error.message, true);
+    }
+    await load();
+}
+
+async function addRecord(event) {
+    event.preventDefault();
+
+    const zone = $("add-zone").value;
+    const type = $("add-type").value;
+    const value = $("add-value").value.trim();
+    const body = {
+        record: { name: $("add-name").value.trim(), type: type, ttl: 1, proxied: $("add-proxied").checked },
+    };
+
+    if (type === "CNAME") {
+        body.CNAME = value;
+    } else if (value) {
+        body.template = value;
+    }
+
+    try {
+        await request("POST", "/api/v1/zones/" + encodeURIComponent(zone) + "/records", body);
+        showMessage("Added " + body.record.name + " " + type + ".");
+        $("add-form").reset();
+    } catch (error) {
+        showMessage(error.message, true);
+    }
+    await load();
+}
+
+$("token").value = localStorage.getItem(tokenKey) || "";
+$("token-form").addEventListener("submit", (event) => {
+    event.preventDefault();
+    const token = $("token").value.trim();
+    if (token) {
+        localStorage.setItem(tokenKey, token);
+    } else {
+        localStorage.removeItem(tokenKey);
+    }
+    load();
+});
+$("refresh").addEventListener("click", () => refresh(false));
+$("force-refresh").addEventListener("click", () => refresh(true));
+$("add-form").addEventListener("submit", addRecord);
+
+load();
+setInterval(load, 30000);
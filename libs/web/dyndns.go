@@ -0,0 +1,235 @@
+package web
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/wasilak/cloudflare-ddns/libs/api"
+	"github.com/wasilak/cloudflare-ddns/libs/cf"
+	"github.com/wasilak/cloudflare-ddns/libs/events"
+)
+
+// dynDNSPath is the update URL of the DynDNS2 protocol spoken by routers.
+const dynDNSPath = "/nic/update"
+
+// Return codes of the DynDNS2 protocol.
+const (
+	DynDNSGood    = "good"
+	DynDNSNoChg   = "nochg"
+	DynDNSBadAuth = "badauth"
+	DynDNSNoHost  = "nohost"
+	DynDNSNotFQDN = "notfqdn"
+	DynDNSDNSErr  = "dnserr"
+)
+
+// DynDNSConfig configures the DynDNS2 endpoint. The endpoint is enabled as soon as a credential is
+// defined.
+type DynDNSConfig struct {
+	Credentials []DynDNSCredential `mapstructure:"credentials"`
+}
+
+// DynDNSCredential is an HTTP basic user allowed to update the given hostnames. Hash is the hashed
+// password, see the hash command.
+type DynDNSCredential struct {
+	Username  string   `mapstructure:"username"`
+	Hash      string   `mapstructure:"hash"`
+	Hostnames []string `mapstructure:"hostnames"`
+}
+
+// The function reports whether the DynDNS2 endpoint is enabled.
+func (d DynDNSConfig) Enabled() bool {
+	return len(d.Credentials) > 0
+}
+
+// The function checks the credentials and normalizes their hostnames.
+func (d *DynDNSConfig) Validate() error {
+	usernames := map[string]bool{}
+
+	for i := range d.Credentials {
+		credential := &d.Credentials[i]
+
+		if credential.Username == "" {
+			return fmt.Errorf("credential %d: username is empty", i)
+		}
+		if usernames[credential.Username] {
+			return fmt.Errorf("credential %d (%s): duplicate username", i, credential.Username)
+		}
+		usernames[credential.Username] = true
+
+		if !validHash(credential.Hash) {
+			return fmt.Errorf("credential %d (%s): hash must be sha256:<hex> or bcrypt", i, credential.Username)
+		}
+
+		if len(credential.Hostnames) == 0 {
+			return fmt.Errorf("credential %d (%s): no hostnames", i, credential.Username)
+		}
+		for j, hostname := range credential.Hostnames {
+			normalized, err := cf.NormalizeZoneName(hostname)
+			if err != nil {
+				return fmt.Errorf("credential %d (%s): %w", i, credential.Username, err)
+			}
+			credential.Hostnames[j] = normalized
+		}
+	}
+
+	return nil
+}
+
+// The function returns the credential matching the basic auth of the request, nil when none does.
+func (d DynDNSConfig) authenticate(r *http.Request) *DynDNSCredential {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil
+	}
+
+	for i := range d.Credentials {
+		if credential := &d.Credentials[i]; credential.Username == username && secretMatches(credential.Hash, password) {
+			return credential
+		}
+	}
+
+	return nil
+}
+
+// The function reports whether the credential may update the hostname.
+func (c *DynDNSCredential) allowsHostname(hostname string) bool {
+	for _, allowed := range c.Hostnames {
+		if allowed == hostname {
+			return true
+		}
+	}
+	return false
+}
+
+// The function implements the DynDNS2 update request, GET /nic/update?hostname=...&myip=...&myipv6=...,
+// for routers that cannot call the REST API. hostname is a comma separated list of managed records,
+// myip updates their A record (or AAAA record for an IPv6 address) and myipv6 their AAAA record. The
+// address of the client is used when neither is given. The answer is a line per hostname.
+//
+// The record is pinned to the address pushed by the router, see api.PinRecord, so the refresh cycles
+// keep it instead of restoring the IP detected by the daemon. The pin is released through the v1 API
+// only.
+func (s *Server) dynDNSUpdate(c echo.Context) error {
+	credential := s.FrameworkOptions.DynDNS.authenticate(c.Request())
+	if credential == nil {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="cloudflare-ddns"`)
+		return c.String(http.StatusUnauthorized, DynDNSBadAuth)
+	}
+
+	hostnames := strings.Split(c.QueryParam("hostname"), ",")
+	addresses := dynDNSAddresses(c)
+
+	lines := make([]string, 0, len(hostnames))
+	for _, hostname := range hostnames {
+		lines = append(lines, s.dynDNSUpdateHost(c, credential, hostname, addresses))
+	}
+
+	return c.String(http.StatusOK, strings.Join(lines, "\n"))
+}
+
+// The function returns the addresses to set by record type, from myip and myipv6, or the address of
+// the client when neither is valid, see ipExtractor.
+func dynDNSAddresses(c echo.Context) map[string]string {
+	addresses := map[string]string{}
+
+	for _, param := range []string{"myip", "myipv6"} {
+		value := c.QueryParam(param)
+		if value == "" {
+			continue
+		}
+
+		address := net.ParseIP(value)
+		switch {
+		case address == nil:
+			slog.WarnContext(c.Request().Context(), "Ignoring invalid DynDNS address", "param", param, "value", value)
+		case address.To4() != nil:
+			addresses["A"] = address.String()
+		default:
+			addresses["AAAA"] = address.String()
+		}
+	}
+
+	if len(addresses) == 0 {
+		if address := net.ParseIP(c.RealIP()); address != nil && address.To4() != nil {
+			addresses["A"] = address.String()
+		} else if address != nil {
+			addresses["AAAA"] = address.String()
+		}
+	}
+
+	return addresses
+}
+
+// The function updates the records of the hostname and returns the DynDNS2 answer for it. Record
+// types without a managed record are skipped, nohost is answered when no type has one.
+func (s *Server) dynDNSUpdateHost(c echo.Context, credential *DynDNSCredential, hostname string, addresses map[string]string) string {
+	ctx := c.Request().Context()
+
+	hostname, err := cf.NormalizeZoneName(hostname)
+	if err != nil || !strings.Contains(hostname, ".") {
+		return DynDNSNotFQDN
+	}
+
+	if !credential.allowsHostname(hostname) {
+		slog.WarnContext(ctx, "DynDNS credential is not allowed to update the hostname", "username", credential.Username, "hostname", hostname)
+		return DynDNSNoHost
+	}
+
+	found := false
+	changed := false
+	updated := []string{}
+
+	for _, recordType := range []string{"A", "AAAA"} {
+		address, ok := addresses[recordType]
+		if !ok {
+			continue
+		}
+
+		record, ok := findRecordByName(hostname, recordType)
+		if !ok {
+			continue
+		}
+		found = true
+		updated = append(updated, address)
+
+		pinned, ok := api.PinnedContent(record.Key())
+		if ok && pinned == address && record.Record.Content == address {
+			continue
+		}
+
+		// The record may already have the address while following the detected IP, it is pinned anyway.
+		changed = changed || record.Record.Content != address
+		record.Record.Content = address
+		previous, err := api.PinRecord(ctx, record, address)
+		publishRecordChange(events.TypeRecordUpdated, record, recordContent(previous), err)
+		if err != nil {
+			slog.With(api.PrepareRecordForLoggiong("record", &record)).ErrorContext(ctx, "DynDNS update failed", "error", err)
+			return DynDNSDNSErr
+		}
+
+		slog.InfoContext(ctx, "DynDNS update", "username", credential.Username, "hostname", hostname, "type", recordType, "content", address)
+	}
+
+	switch {
+	case !found:
+		return DynDNSNoHost
+	case changed:
+		return DynDNSGood + " " + strings.Join(updated, ",")
+	default:
+		return DynDNSNoChg + " " + strings.Join(updated, ",")
+	}
+}
+
+// The function returns a copy of the managed record with the given name and type, in any zone.
+func findRecordByName(name, recordType string) (cf.ExtendedCloudflareDNSRecord, bool) {
+	for _, record := range api.Records.Snapshot() {
+		if record.Record != nil && record.Record.Name == name && string(record.Record.Type) == recordType {
+			return record, true
+		}
+	}
+	return cf.ExtendedCloudflareDNSRecord{}, false
+}
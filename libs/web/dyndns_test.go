@@ -0,0 +1,237 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/wasilak/cloudflare-ddns/libs"
+	"github.com/wasilak/cloudflare-ddns/libs/api"
+	"github.com/wasilak/cloudflare-ddns/libs/cf"
+	"github.com/wasilak/cloudflare-ddns/libs/ip"
+	"github.com/wasilak/cloudflare-ddns/libs/storage"
+)
+
+// The function sends a DynDNS update as the router and returns the answer.
+func (e *testEnv) dynDNSUpdate(t *testing.T, hostname, address string) string {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, e.server.URL+dynDNSPath+"?hostname="+hostname+"&myip="+address, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("router", "secret")
+
+	resp, err := e.server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// The function returns the content of the record of the fake API with the given name.
+func (e *testEnv) content(t *testing.T, name string) string {
+	t.Helper()
+
+	for _, record := range e.fake.Records(e.zoneID) {
+		if record.Name == name {
+			return record.Content
+		}
+	}
+	t.Fatalf("no record %s", name)
+	return ""
+}
+
+// The function runs a cycle over the managed records with the given detected address.
+func (e *testEnv) runCycle(t *testing.T, address string) {
+	t.Helper()
+
+	ip.SetCurrent(&ip.IP{IP: address})
+	if _, err := libs.Runner(context.Background(), api.Records.Snapshot(), api.IPLists); err != nil {
+		t.Fatalf("cycle with %s: %v", address, err)
+	}
+}
+
+// The function returns the pin of the record as answered by the v1 API.
+func (e *testEnv) pinned(t *testing.T, name string) string {
+	t.Helper()
+
+	resp, err := e.server.Client().Get(e.server.URL + "/api/v1/zones/example.com/records/" + name + "/A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var state RecordState
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		t.Fatal(err)
+	}
+	return state.Pinned
+}
+
+func TestDynDNSPinsAddressUntilReleased(t *testing.T) {
+	env := newTestEnv(t, "home.example.com")
+
+	store, err := storage.Open(filepath.Join(t.TempDir(), "records.db"), storage.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	api.Storage = store
+
+	env.runCycle(t, "203.0.113.1")
+
+	if answer := env.dynDNSUpdate(t, "home.example.com", "198.51.100.7"); answer != DynDNSGood+" 198.51.100.7" {
+		t.Fatalf("update answer %q", answer)
+	}
+	if answer := env.dynDNSUpdate(t, "home.example.com", "198.51.100.7"); answer != DynDNSNoChg+" 198.51.100.7" {
+		t.Errorf("repeated update answer %q", answer)
+	}
+
+	if pinned := env.pinned(t, "home.example.com"); pinned != "198.51.100.7" {
+		t.Errorf("pinned %q, want 198.51.100.7", pinned)
+	}
+	key := api.FindDNSRecord("example.com", "home.example.com", "A").Key()
+	if pins, err := store.Pins(); err != nil || pins[key] != "198.51.100.7" {
+		t.Errorf("stored pins %v, %v", pins, err)
+	}
+
+	// The pin takes precedence over the detected address.
+	env.runCycle(t, "203.0.113.2")
+	if got := env.content(t, "home.example.com"); got != "198.51.100.7" {
+		t.Errorf("content %s after the IP changed, want the pinned 198.51.100.7", got)
+	}
+
+	status := env.do(t, http.MethodDelete, "/api/v1/zones/example.com/records/home.example.com/A/pin", nil)
+	if status != http.StatusOK {
+		t.Fatalf("releasing the pin: status %d", status)
+	}
+	if got := env.content(t, "home.example.com"); got != "203.0.113.2" {
+		t.Errorf("content %s after the release, want the detected 203.0.113.2", got)
+	}
+	if pinned := env.pinned(t, "home.example.com"); pinned != "" {
+		t.Errorf("pinned %q after the release", pinned)
+	}
+	if pins, err := store.Pins(); err != nil || len(pins) != 0 {
+		t.Errorf("stored pins %v, %v after the release", pins, err)
+	}
+}
+
+// Updating a pinned record through the legacy API, which knows nothing about pins, keeps the pin.
+func TestLegacyUpdateKeepsPin(t *testing.T) {
+	env := newTestEnv(t, "home.example.com")
+	env.runCycle(t, "203.0.113.1")
+
+	if answer := env.dynDNSUpdate(t, "home.example.com", "198.51.100.7"); answer != DynDNSGood+" 198.51.100.7" {
+		t.Fatalf("update answer %q", answer)
+	}
+
+	record := map[string]any{"zone_name": "example.com", "record": map[string]any{"name": "home.example.com", "type": "A", "ttl": 300}}
+	if status := env.do(t, http.MethodPost, "/api/", record); status != http.StatusCreated {
+		t.Fatalf("legacy update: status %d", status)
+	}
+
+	if pinned := env.pinned(t, "home.example.com"); pinned != "198.51.100.7" {
+		t.Errorf("pinned %q after the legacy update, want 198.51.100.7", pinned)
+	}
+	if got := env.content(t, "home.example.com"); got != "198.51.100.7" {
+		t.Errorf("content %s after the legacy update, want the pinned 198.51.100.7", got)
+	}
+}
+
+// Pins are not part of the record definitions, so they cannot be written by the REST API, the
+// configuration or the environment.
+func TestPinIsNotARecordField(t *testing.T) {
+	var record cf.ExtendedCloudflareDNSRecord
+	if err := json.Unmarshal([]byte(`{"zone_name":"example.com","pinned":"198.51.100.7","record":{"name":"home.example.com","type":"A"}}`), &record); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "198.51.100.7") {
+		t.Errorf("record %s kept the pinned content", data)
+	}
+}
+
+func TestReleasePinOfUnmanagedRecord(t *testing.T) {
+	env := newTestEnv(t, "home.example.com")
+
+	status := env.do(t, http.MethodDelete, "/api/v1/zones/example.com/records/other.example.com/A/pin", nil)
+	if status != http.StatusNotFound {
+		t.Errorf("status %d, want 404", status)
+	}
+}
+
+// Without trusted proxies, the address of the client is the one of the connection, whatever the
+// forwarding headers claim.
+func TestDynDNSIgnoresForwardedAddress(t *testing.T) {
+	env := newTestEnv(t, "home.example.com")
+	env.runCycle(t, "203.0.113.1")
+
+	req, err := http.NewRequest(http.MethodGet, env.server.URL+dynDNSPath+"?hostname=home.example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("router", "secret")
+	req.Header.Set(echo.HeaderXForwardedFor, "198.51.100.9")
+	req.Header.Set(echo.HeaderXRealIP, "198.51.100.9")
+
+	resp, err := env.server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got := env.content(t, "home.example.com"); got != "127.0.0.1" {
+		t.Errorf("content %s, want the address of the connection 127.0.0.1", got)
+	}
+}
+
+func TestIPExtractorTrustsConfiguredProxies(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		proxies []*net.IPNet
+		remote  string
+		want    string
+	}{
+		{name: "no trusted proxy", remote: "10.0.0.1:4321", want: "10.0.0.1"},
+		{name: "trusted network", proxies: trusted, remote: "10.0.0.1:4321", want: "198.51.100.9"},
+		{name: "trusted address", proxies: trusted, remote: "192.0.2.1:4321", want: "198.51.100.9"},
+		{name: "untrusted proxy", proxies: trusted, remote: "192.0.2.2:4321", want: "192.0.2.2"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, dynDNSPath, nil)
+		req.RemoteAddr = tt.remote
+		req.Header.Set(echo.HeaderXForwardedFor, "198.51.100.9")
+
+		if got := ipExtractor(tt.proxies)(req); got != tt.want {
+			t.Errorf("%s: client address %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := ParseTrustedProxies([]string{"proxy.example.com"}); err == nil {
+		t.Error("ParseTrustedProxies accepted a hostname")
+	}
+}
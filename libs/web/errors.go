@@ -0,0 +1,143 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/wasilak/cloudflare-ddns/libs"
+	"github.com/wasilak/cloudflare-ddns/libs/cf"
+	"github.com/wasilak/cloudflare-ddns/libs/hooks"
+)
+
+// The function returns the HTTP status matching an error returned by the api package. Errors caused
+// by the DNS provider rejecting our own credentials, or by the external IP being unavailable, are
+// reported as 502, as the client is not at fault.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, cf.ErrValidation):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, cf.ErrRecordNotFound), errors.Is(err, cf.ErrZoneNotFound), errors.Is(err, cf.ErrIPListNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, cf.ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, cf.ErrUnauthorized), errors.Is(err, libs.ErrIPUnavailable):
+		return http.StatusBadGateway
+	case errors.Is(err, hooks.ErrVetoed):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Codes identifying the kind of error in ErrorResponse, stable across messages.
+const (
+	CodeInvalidRequest       = "invalid_request"
+	CodeValidationFailed     = "validation_failed"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeRecordNotFound       = "record_not_found"
+	CodeZoneNotFound         = "zone_not_found"
+	CodeIPListNotFound       = "ip_list_not_found"
+	CodeConflict             = "conflict"
+	CodeVetoed               = "vetoed"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeRateLimited          = "rate_limited"
+	CodeProviderUnauthorized = "provider_unauthorized"
+	CodeIPUnavailable        = "ip_unavailable"
+	CodeUnavailable          = "unavailable"
+	CodeInternal             = "internal_error"
+)
+
+// The function returns the code matching an error returned by the api package, see errorStatus.
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, cf.ErrValidation):
+		return CodeValidationFailed
+	case errors.Is(err, cf.ErrRecordNotFound):
+		return CodeRecordNotFound
+	case errors.Is(err, cf.ErrZoneNotFound):
+		return CodeZoneNotFound
+	case errors.Is(err, cf.ErrIPListNotFound):
+		return CodeIPListNotFound
+	case errors.Is(err, cf.ErrRateLimited):
+		return CodeRateLimited
+	case errors.Is(err, cf.ErrUnauthorized):
+		return CodeProviderUnauthorized
+	case errors.Is(err, libs.ErrIPUnavailable):
+		return CodeIPUnavailable
+	case errors.Is(err, hooks.ErrVetoed):
+		return CodeVetoed
+	default:
+		return CodeInternal
+	}
+}
+
+// The function returns the body describing an error, listing every problem of invalid records.
+func errorResponse(message string, err error) ErrorResponse {
+	response := ErrorResponse{
+		Code:    errorCode(err),
+		Message: message,
+		Error:   err.Error(),
+	}
+
+	var validationError *cf.ValidationError
+	if errors.As(err, &validationError) {
+		response.Error = "validation failed"
+		response.Errors = validationError.Errors
+	}
+
+	return response
+}
+
+// The function answers errors not produced by the handlers, such as unknown routes, with an
+// ErrorResponse like every other error of the API.
+func httpErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	status := http.StatusInternalServerError
+	detail := http.StatusText(status)
+	var httpError *echo.HTTPError
+	if errors.As(err, &httpError) {
+		if response, ok := httpError.Message.(ErrorResponse); ok {
+			if err := c.JSON(httpError.Code, response); err != nil {
+				slog.ErrorContext(c.Request().Context(), "Error response not sent", "error", err)
+			}
+			return
+		}
+
+		status = httpError.Code
+		detail = fmt.Sprint(httpError.Message)
+	}
+
+	code := CodeInternal
+	switch status {
+	case http.StatusBadRequest:
+		code = CodeInvalidRequest
+	case http.StatusNotFound:
+		code = CodeNotFound
+	case http.StatusMethodNotAllowed:
+		code = CodeMethodNotAllowed
+	}
+
+	if status >= http.StatusInternalServerError {
+		slog.ErrorContext(c.Request().Context(), "Request failed", "error", err)
+		detail = http.StatusText(status)
+	}
+
+	response := ErrorResponse{Code: code, Message: http.StatusText(status), Error: detail}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(status)
+	} else {
+		err = c.JSON(status, response)
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request().Context(), "Error response not sent", "error", err)
+	}
+}
@@ -0,0 +1,188 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/wasilak/cloudflare-ddns/libs/api"
+	"github.com/wasilak/cloudflare-ddns/libs/cf"
+	"github.com/wasilak/cloudflare-ddns/libs/events"
+	"golang.org/x/net/websocket"
+)
+
+// eventsPath is where the events are streamed.
+const eventsPath = "/api/events"
+
+// keepaliveInterval is how often a comment is sent on idle Server-Sent Events streams, so proxies do
+// not close them.
+const keepaliveInterval = 30 * time.Second
+
+// The function streams the events of the daemon as they happen, over a WebSocket when the request asks
+// for an upgrade and as Server-Sent Events otherwise. The types query parameter keeps the events of the
+// given types only. Credentials restricted to some zones only receive the events of these zones and
+// the IP events.
+func (s *Server) apiEvents(c echo.Context) error {
+	types := map[string]bool{}
+	if value := c.QueryParam("types"); value != "" {
+		for _, eventType := range strings.Split(value, ",") {
+			types[eventType] = true
+		}
+	}
+
+	credential := principal(c)
+	visible := func(event events.Event) bool {
+		if len(types) > 0 && !types[event.Type] {
+			return false
+		}
+		switch {
+		case credential == nil:
+			return true
+		case event.ZoneName != "":
+			return credential.AllowsZone(event.ZoneName)
+		case event.IPList != "":
+			return len(credential.Zones) == 0
+		default:
+			return true
+		}
+	}
+
+	if strings.EqualFold(c.Request().Header.Get(echo.HeaderUpgrade), "websocket") {
+		return streamWebSocket(c, visible)
+	}
+
+	return streamSSE(c, visible)
+}
+
+// The function writes the events as Server-Sent Events until the client goes away.
+func streamSSE(c echo.Context, visible func(events.Event) bool) error {
+	subscription := events.Default.Subscribe()
+	defer events.Default.Unsubscribe(subscription)
+
+	response := c.Response()
+	response.Header().Set(echo.HeaderContentType, "text/event-stream")
+	response.Header().Set(echo.HeaderCacheControl, "no-cache")
+	response.Header().Set(echo.HeaderConnection, "keep-alive")
+	// Reverse proxies such as nginx would otherwise buffer the stream.
+	response.Header().Set("X-Accel-Buffering", "no")
+	response.WriteHeader(http.StatusOK)
+	response.Flush()
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(response, ": keepalive\n\n"); err != nil {
+				return nil
+			}
+			response.Flush()
+		case event := <-subscription.C:
+			if !visible(event) {
+				continue
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+
+			if _, err := fmt.Fprintf(response, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+				return nil
+			}
+			response.Flush()
+		}
+	}
+}
+
+// The function sends the events as JSON messages over a WebSocket until the client closes it.
+// Cross-origin upgrades are refused, as browsers would send the basic credentials of the page along.
+func streamWebSocket(c echo.Context, visible func(events.Event) bool) error {
+	server := websocket.Server{
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				return nil
+			}
+			if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+				return fmt.Errorf("cross-origin WebSocket from %q", origin)
+			}
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+
+			subscription := events.Default.Subscribe()
+			defer events.Default.Unsubscribe(subscription)
+
+			// Messages from the client are ignored, reading only tells when the connection is closed.
+			closed := make(chan struct{})
+			go func() {
+				defer close(closed)
+				var message string
+				for {
+					if err := websocket.Message.Receive(ws, &message); err != nil {
+						return
+					}
+				}
+			}()
+
+			for {
+				select {
+				case <-closed:
+					return
+				case event := <-subscription.C:
+					if !visible(event) {
+						continue
+					}
+					if err := websocket.JSON.Send(ws, event); err != nil {
+						return
+					}
+				}
+			}
+		},
+	}
+
+	server.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// The function publishes the change of the record made through the API: an event of eventType on
+// success and events.TypeUpdateFailed when the change failed at the provider. Rejected requests are
+// not published. oldContent is the content before the change, empty on creation.
+func publishRecordChange(eventType string, record cf.ExtendedCloudflareDNSRecord, oldContent string, err error) {
+	if status := errorStatus(err); err != nil && status < http.StatusInternalServerError && status != http.StatusTooManyRequests {
+		return
+	}
+
+	event := events.Event{Type: eventType, ZoneName: record.ZoneName, OldContent: oldContent, DryRun: api.DryRun}
+	if record.Record != nil {
+		event.Name = record.Record.Name
+		event.RecordType = string(record.Record.Type)
+		if eventType != events.TypeRecordDeleted {
+			event.NewContent = record.Record.Content
+		}
+	}
+
+	if err != nil {
+		event.Type = events.TypeUpdateFailed
+		event.Error = err.Error()
+	}
+
+	events.Publish(event)
+}
+
+// The function returns the content of the record, empty when there is none.
+func recordContent(record *cf.ExtendedCloudflareDNSRecord) string {
+	if record == nil || record.Record == nil {
+		return ""
+	}
+	return record.Record.Content
+}
@@ -0,0 +1,109 @@
+package web
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/labstack/echo/v4"
+)
+
+// openAPISpec is the OpenAPI 3 document describing the REST API, served at /api/openapi.json and
+// used to validate requests.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// The function serves the OpenAPI document.
+func (s *Server) apiOpenAPI(c echo.Context) error {
+	return c.Blob(http.StatusOK, echo.MIMEApplicationJSON, openAPISpec)
+}
+
+// The function loads the embedded OpenAPI document and returns the router matching requests to its
+// operations.
+func openAPIRouter() (routers.Router, error) {
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(openAPISpec)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := doc.Validate(loader.Context); err != nil {
+		return nil, err
+	}
+
+	return gorillamux.NewRouter(doc)
+}
+
+// The function returns the middleware validating the parameters and bodies of /api requests against
+// the OpenAPI document, answering 400 to invalid ones. Requests the document does not describe are
+// left to the router. Credentials are checked by authMiddleware, not here.
+func openAPIValidationMiddleware(router routers.Router) echo.MiddlewareFunc {
+	options := &openapi3filter.Options{
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !strings.HasPrefix(c.Request().URL.Path, "/api/") {
+				return next(c)
+			}
+
+			route, pathParams, err := router.FindRoute(c.Request())
+			if err != nil {
+				return next(c)
+			}
+
+			input := &openapi3filter.RequestValidationInput{
+				Request:    c.Request(),
+				PathParams: pathParams,
+				Route:      route,
+				Options:    options,
+			}
+
+			if err := openapi3filter.ValidateRequest(context.WithoutCancel(c.Request().Context()), input); err != nil {
+				return c.JSON(http.StatusBadRequest, ErrorResponse{
+					Code:    CodeInvalidRequest,
+					Message: "Invalid request",
+					Error:   validationMessage(err),
+				})
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// The function returns a readable description of a request validation error, without the dump of
+// the schema kin-openapi appends to it.
+func validationMessage(err error) string {
+	var requestError *openapi3filter.RequestError
+	if !errors.As(err, &requestError) {
+		return err.Error()
+	}
+
+	message := requestError.Reason
+	if requestError.Parameter != nil {
+		message = strings.TrimSpace(message + " parameter " + requestError.Parameter.Name)
+	}
+
+	var schemaError *openapi3.SchemaError
+	if errors.As(requestError.Err, &schemaError) {
+		if pointer := schemaError.JSONPointer(); len(pointer) > 0 {
+			message = strings.TrimSpace(message + " " + strings.Join(pointer, "."))
+		}
+		return message + ": " + schemaError.Reason
+	}
+
+	if requestError.Err != nil {
+		message = strings.TrimSpace(message + ": " + requestError.Err.Error())
+	}
+
+	return message
+}
@@ -0,0 +1,68 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/wasilak/cloudflare-ddns/libs"
+	"github.com/wasilak/cloudflare-ddns/libs/api"
+	"github.com/wasilak/cloudflare-ddns/libs/cf"
+)
+
+// The function runs a refresh cycle, the same way the daemon does on every tick, and answers its
+// results. A credential restricted to some zones only refreshes the records of these zones, unless the
+// IP changed, and only sees their results.
+func (s *Server) apiRefresh(c echo.Context) error {
+	refresher := s.FrameworkOptions.Refresher
+	if refresher == nil {
+		return c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Code:    CodeUnavailable,
+			Message: "Refresh not available",
+			Error:   "refresh cycles are only run by the daemon",
+		})
+	}
+
+	options := libs.RefreshOptions{}
+	if err := c.Bind(&options); err != nil {
+		return invalidRequest(c, err)
+	}
+
+	for _, selector := range options.Records {
+		if !allowsZone(c, selector.ZoneName) {
+			return forbiddenZone(c, selector.ZoneName)
+		}
+	}
+
+	if credential := principal(c); credential != nil && len(credential.Zones) > 0 && len(options.Records) == 0 {
+		for _, record := range api.Records.Snapshot() {
+			if credential.AllowsZone(record.ZoneName) {
+				options.Records = append(options.Records, libs.RecordSelector{ZoneName: record.ZoneName, Name: record.Record.Name, Type: string(record.Record.Type)})
+			}
+		}
+
+		if len(options.Records) == 0 {
+			err := fmt.Errorf("%w: no managed record in the zones of the credential", cf.ErrRecordNotFound)
+			return c.JSON(errorStatus(err), errorResponse("Refresh not run", err))
+		}
+	}
+
+	result, err := refresher.Refresh(c.Request().Context(), options)
+	if err != nil {
+		return c.JSON(errorStatus(err), errorResponse("Refresh failed", err))
+	}
+
+	records := make([]api.RecordResult, 0, len(result.Records))
+	for _, recordResult := range result.Records {
+		if allowsZone(c, recordResult.Record.ZoneName) {
+			records = append(records, recordResult)
+		}
+	}
+	result.Records = records
+
+	if credential := principal(c); credential != nil && len(credential.Zones) > 0 {
+		result.IPLists = []api.IPListResult{}
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,80 @@
+package web
+
+import (
+	"github.com/wasilak/cloudflare-ddns/libs/cf"
+)
+
+// ErrorResponse is the body of every error answered by the API. Code is one of the Code* constants,
+// Errors lists the problems of invalid records, RecordName and ZoneName identify the record a deletion
+// failed for.
+type ErrorResponse struct {
+	Code       string           `json:"code"`
+	Message    string           `json:"message"`
+	Error      string           `json:"error"`
+	Errors     []cf.RecordError `json:"errors,omitempty"`
+	RecordName string           `json:"recordName,omitempty"`
+	ZoneName   string           `json:"zoneName,omitempty"`
+}
+
+// RecordResponse is the body answered when a record is created or updated.
+type RecordResponse struct {
+	Message string                         `json:"message"`
+	Record  cf.ExtendedCloudflareDNSRecord `json:"record"`
+}
+
+// DeleteResponse is the body answered when a record is deleted.
+type DeleteResponse struct {
+	Message    string `json:"message"`
+	RecordName string `json:"recordName"`
+	ZoneName   string `json:"zoneName"`
+}
+
+// MessageResponse is the body of answers carrying nothing but a message.
+type MessageResponse struct {
+	Message string `json:"message"`
+}
+
+// Zone is a zone the DNS provider manages, with the number of records managed in it.
+type Zone struct {
+	Name    string `json:"name"`
+	Records int    `json:"records"`
+}
+
+// Pagination describes the page of a list answered by the v1 API.
+type Pagination struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	Total      int `json:"total"`
+	TotalPages int `json:"total_pages"`
+}
+
+// ZoneList is the body answered when listing zones.
+type ZoneList struct {
+	Zones      []Zone     `json:"zones"`
+	Pagination Pagination `json:"pagination"`
+}
+
+// RecordList is the body answered when listing the records of a zone.
+type RecordList struct {
+	Records    []RecordState `json:"records"`
+	Pagination Pagination    `json:"pagination"`
+}
+
+// RecordState is a managed record, with the content a DynDNS update pinned it to, if any, and its live
+// state at the DNS provider when requested. Pins are managed by the server: they are set by the DynDNS
+// endpoint and released through the v1 API only.
+type RecordState struct {
+	cf.ExtendedCloudflareDNSRecord
+	Pinned string     `json:"pinned,omitempty"`
+	Live   *LiveState `json:"live,omitempty"`
+}
+
+// LiveState is the state of a managed record at the DNS provider. InSync tells whether its content and
+// proxy status are the desired ones.
+type LiveState struct {
+	Found   bool    `json:"found"`
+	Content string  `json:"content,omitempty"`
+	Proxied bool    `json:"proxied"`
+	TTL     float64 `json:"ttl,omitempty"`
+	InSync  bool    `json:"in_sync"`
+}
@@ -1,12 +1,17 @@
 package web
 
 import (
+	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strings"
 
+	"github.com/cloudflare/cloudflare-go/v4/dns"
 	"github.com/labstack/echo/v4"
 	"github.com/wasilak/cloudflare-ddns/libs/api"
 	"github.com/wasilak/cloudflare-ddns/libs/cf"
+	"github.com/wasilak/cloudflare-ddns/libs/events"
 )
 
 func (s *Server) healthRoute(c echo.Context) error {
@@ -16,70 +21,125 @@ func (s *Server) healthRoute(c echo.Context) error {
 
 func (s *Server) apiList(c echo.Context) error {
 	c.Response().Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
-	return c.JSON(http.StatusOK, api.Records)
+	records := make([]cf.ExtendedCloudflareDNSRecord, 0)
+	for _, record := range api.Records.Snapshot() {
+		if allowsZone(c, record.ZoneName) {
+			records = append(records, record)
+		}
+	}
+
+	return c.JSON(http.StatusOK, records)
+}
+
+// The function returns the outcome of the latest run over the records and IP Lists.
+func (s *Server) apiResults(c echo.Context) error {
+	result, ok := api.LastRun()
+	if !ok {
+		return c.JSON(http.StatusNotFound, MessageResponse{Message: "No run completed yet"})
+	}
+
+	records := make([]api.RecordResult, 0, len(result.Records))
+	for _, recordResult := range result.Records {
+		if allowsZone(c, recordResult.Record.ZoneName) {
+			records = append(records, recordResult)
+		}
+	}
+	result.Records = records
+
+	return c.JSON(http.StatusOK, result)
 }
 
 func (s *Server) apiDelete(c echo.Context) error {
 	recordName := c.Param("record_name")
 	zoneName := c.Param("zone_name")
+	recordType := c.QueryParam("type")
 
-	var response map[string]any
-	var httpStatus int
+	if !allowsZone(c, zoneName) {
+		return forbiddenZone(c, zoneName)
+	}
 
-	_, err := api.DeleteRecord(c.Request().Context(), recordName, zoneName)
-	if err != nil {
-		if err.Error() == "record not found" {
-			response = map[string]any{
-				"message":    "Record not foud",
-				"recordName": recordName,
-				"zoneName":   zoneName,
-				"error":      err.Error(),
-			}
-			httpStatus = http.StatusNotFound
-		} else {
-			response = map[string]any{
-				"message":    "Record not deleted",
-				"recordName": recordName,
-				"zoneName":   zoneName,
-				"error":      err.Error(),
-			}
-			httpStatus = http.StatusInternalServerError
-		}
-	} else {
-		response = map[string]any{
-			"message":    "Record deleted",
-			"recordName": recordName,
-			"zoneName":   zoneName,
+	var deleted *cf.ExtendedCloudflareDNSRecord
+	var err error
+	if recordType == "" {
+		recordType, err = legacyRecordType(zoneName, recordName)
+	}
+	if err == nil {
+		deleted, err = api.DeleteRecord(c.Request().Context(), recordName, zoneName, recordType)
+	}
+	if deleted == nil {
+		deleted = &cf.ExtendedCloudflareDNSRecord{
+			Record:   &dns.RecordResponse{Name: recordName, Type: dns.RecordResponseType(recordType)},
+			ZoneName: zoneName,
 		}
-		httpStatus = http.StatusOK
+	}
+	publishRecordChange(events.TypeRecordDeleted, *deleted, deleted.Record.Content, err)
+	if err != nil {
+		response := errorResponse("Record not deleted", err)
+		response.RecordName = recordName
+		response.ZoneName = zoneName
+		return c.JSON(errorStatus(err), response)
 	}
 
 	c.Response().Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
-	return c.JSON(httpStatus, response)
+	return c.JSON(http.StatusOK, DeleteResponse{
+		Message:    "Record deleted",
+		RecordName: recordName,
+		ZoneName:   zoneName,
+	})
+}
+
+// The function returns the type of the managed records with the given name, for the legacy routes
+// predating the lookup of records by type: any type matches, as long as the managed records with the
+// name share it.
+func legacyRecordType(zoneName, recordName string) (string, error) {
+	lookup := cf.ExtendedCloudflareDNSRecord{Record: &dns.RecordResponse{Name: recordName}, ZoneName: zoneName}
+	if err := lookup.Normalize(); err != nil {
+		return "", fmt.Errorf("%w: %w", cf.ErrValidation, err)
+	}
+
+	types := []string{}
+	for _, record := range api.Records.Snapshot() {
+		if record.ZoneName == lookup.ZoneName && record.Record.Name == lookup.Record.Name && !slices.Contains(types, string(record.Record.Type)) {
+			types = append(types, string(record.Record.Type))
+		}
+	}
+
+	switch len(types) {
+	case 0:
+		return "", fmt.Errorf("%w: %s", cf.ErrRecordNotFound, lookup.Record.Name)
+	case 1:
+		return types[0], nil
+	default:
+		return "", fmt.Errorf("%w: several records are named %s (%s), the type parameter is required", cf.ErrValidation, lookup.Record.Name, strings.Join(types, ", "))
+	}
 }
 
 func (s *Server) apiCreate(c echo.Context) error {
 	record := cf.ExtendedCloudflareDNSRecord{}
 	if err := c.Bind(&record); err != nil {
-		return err
+		return c.JSON(http.StatusBadRequest, ErrorResponse{Code: CodeInvalidRequest, Message: "Invalid request", Error: err.Error()})
+	}
+
+	var response RecordResponse
+
+	if !allowsZone(c, record.ZoneName) {
+		return forbiddenZone(c, record.ZoneName)
 	}
 
-	var response map[string]any
+	// Records created through the API are persisted, whatever origin the client claims.
+	record.Origin = cf.OriginAPI
 
 	slog.InfoContext(c.Request().Context(), "Creating record", "record", record)
 
 	_, err := api.AddRecord(c.Request().Context(), &record)
+	publishRecordChange(events.TypeRecordCreated, record, "", err)
 	if err != nil {
-		response = map[string]any{
-			"message": "Record not created",
-			"error":   err.Error(),
-		}
-		return c.JSON(http.StatusInternalServerError, response)
+		return c.JSON(errorStatus(err), errorResponse("Record not created", err))
 	}
 
-	response = map[string]any{
-		"message": "Record created",
-		"record":  record,
+	response = RecordResponse{
+		Message: "Record created",
+		Record:  record,
 	}
 
 	c.Response().Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
@@ -89,25 +149,42 @@ func (s *Server) apiCreate(c echo.Context) error {
 func (s *Server) apiUpdate(c echo.Context) error {
 	record := cf.ExtendedCloudflareDNSRecord{}
 	if err := c.Bind(&record); err != nil {
-		return err
+		return c.JSON(http.StatusBadRequest, ErrorResponse{Code: CodeInvalidRequest, Message: "Invalid request", Error: err.Error()})
 	}
 
-	var response map[string]any
+	var response RecordResponse
+
+	if !allowsZone(c, record.ZoneName) {
+		return forbiddenZone(c, record.ZoneName)
+	}
 
-	_, err := api.UpdateRecord(c.Request().Context(), &record)
+	// The origin of a managed record is kept, see api.UpdateRecord.
+	record.Origin = ""
+
+	previous, err := api.UpdateRecord(c.Request().Context(), &record)
+	publishRecordChange(events.TypeRecordUpdated, record, recordContent(previous), err)
 	if err != nil {
-		response = map[string]any{
-			"message": "Record not updated",
-			"error":   err.Error(),
-		}
-		return c.JSON(http.StatusInternalServerError, response)
+		return c.JSON(errorStatus(err), errorResponse("Record not updated", err))
 	}
 
-	response = map[string]any{
-		"message": "Record updated",
-		"record":  record,
+	response = RecordResponse{
+		Message: "Record updated",
+		Record:  record,
 	}
 
 	c.Response().Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	return c.JSON(http.StatusCreated, response)
 }
+
+// The function returns the middleware flagging the responses of a deprecated route and
+// pointing clients to the route replacing it.
+func deprecated(successor string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Response().Header().Set("Deprecation", "true")
+			c.Response().Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+			slog.DebugContext(c.Request().Context(), "Deprecated route called", "route", c.Path(), "successor", successor)
+			return next(c)
+		}
+	}
+}
@@ -2,7 +2,11 @@ package web
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net"
+	"net/http"
 	"strings"
 
 	"github.com/labstack/echo-contrib/echoprometheus"
@@ -16,15 +20,40 @@ import (
 
 type Server struct {
 	Server *echo.Echo
+	// MetricsServer serves /metrics when FrameworkOptions.MetricsListenAddr is set.
+	MetricsServer *echo.Echo
 	*WebServer
 }
 
 func (s *Server) Start(ctx context.Context, frameworkOptions FrameworkOptions) {
+	s.setup()
+
 	go func() {
-		s.setup()
-		slog.DebugContext(ctx, "Starting server", "address", s.FrameworkOptions.ListenAddr)
-		s.Server.Start(s.FrameworkOptions.ListenAddr)
+		server := &http.Server{Addr: s.FrameworkOptions.ListenAddr}
+
+		if s.FrameworkOptions.TLS.Enabled() {
+			tlsConfig, err := s.FrameworkOptions.TLS.serverConfig()
+			if err != nil {
+				slog.ErrorContext(ctx, "Invalid TLS configuration", "error", err)
+				return
+			}
+			server.TLSConfig = tlsConfig
+		}
+
+		slog.DebugContext(ctx, "Starting server", "address", s.FrameworkOptions.ListenAddr, "tls", server.TLSConfig != nil)
+		if err := s.Server.StartServer(server); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.ErrorContext(ctx, "Server stopped", "error", err)
+		}
 	}()
+
+	if s.MetricsServer != nil {
+		go func() {
+			slog.DebugContext(ctx, "Starting metrics server", "address", s.FrameworkOptions.MetricsListenAddr)
+			if err := s.MetricsServer.Start(s.FrameworkOptions.MetricsListenAddr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.ErrorContext(ctx, "Metrics server stopped", "error", err)
+			}
+		}()
+	}
 }
 
 func (s *Server) setup() {
@@ -32,6 +61,8 @@ func (s *Server) setup() {
 
 	s.Server.HideBanner = true
 	s.Server.HidePort = true
+	s.Server.HTTPErrorHandler = httpErrorHandler
+	s.Server.IPExtractor = ipExtractor(s.FrameworkOptions.TrustedProxies)
 
 	s.Server.Debug = strings.EqualFold(s.FrameworkOptions.LogLevelConfig.Level().String(), "debug")
 
@@ -39,13 +70,14 @@ func (s *Server) setup() {
 
 	if s.FrameworkOptions.OtelEnabled {
 		s.Server.Use(otelecho.Middleware(libs.GetAppName(), otelecho.WithSkipper(func(c echo.Context) bool {
-			return strings.Contains(c.Path(), "metrics") || strings.Contains(c.Path(), "health")
+			return strings.Contains(c.Path(), "metrics") || strings.Contains(c.Path(), "health") || c.Path() == "/livez" || c.Path() == "/readyz"
 		})))
 	}
 
 	s.Server.Use(middleware.GzipWithConfig(middleware.GzipConfig{
 		Skipper: func(c echo.Context) bool {
-			return strings.Contains(c.Path(), "metrics")
+			// Event streams are flushed event by event, compressing them would only delay the events.
+			return strings.Contains(c.Path(), "metrics") || c.Path() == eventsPath
 		},
 	}))
 
@@ -55,11 +87,100 @@ func (s *Server) setup() {
 	}
 	s.Server.Use(echoprometheus.NewMiddlewareWithConfig(echoprometheusConfig))
 
+	if s.FrameworkOptions.Auth.Enabled() {
+		s.Server.Use(authMiddleware(s.FrameworkOptions.Auth))
+	} else {
+		slog.Warn("No API credentials configured, the REST API is not authenticated")
+	}
+
+	router, err := openAPIRouter()
+	if err != nil {
+		// The document is embedded, failing to load it is a bug rather than a runtime condition.
+		panic(err)
+	}
+	s.Server.Use(openAPIValidationMiddleware(router))
+
 	s.Server.GET("/health", s.healthRoute)
-	s.Server.GET("/api/list", s.apiList)
-	s.Server.PUT("/api/", s.apiCreate)
-	s.Server.POST("/api/", s.apiUpdate)
-	s.Server.DELETE("/api/:zone_name/:record_name", s.apiDelete)
+	s.Server.GET("/livez", s.livezRoute)
+	s.Server.GET("/readyz", s.readyzRoute)
+	s.Server.GET("/status", s.statusRoute)
+	s.Server.GET("/api/openapi.json", s.apiOpenAPI)
+	s.Server.GET("/api/results", s.apiResults)
+	s.Server.POST("/api/refresh", s.apiRefresh)
+	s.Server.GET(eventsPath, s.apiEvents)
+	s.registerV1(s.Server.Group("/api/v1"))
+
+	// Routes predating /api/v1, kept for existing clients.
+	s.Server.GET("/api/list", s.apiList, deprecated("/api/v1/zones"))
+	s.Server.PUT("/api/", s.apiCreate, deprecated("/api/v1/zones/{zone}/records"))
+	s.Server.POST("/api/", s.apiUpdate, deprecated("/api/v1/zones/{zone}/records/{name}/{type}"))
+	s.Server.DELETE("/api/:zone_name/:record_name", s.apiDelete, deprecated("/api/v1/zones/{zone}/records/{name}/{type}"))
+
+	if s.FrameworkOptions.MetricsListenAddr == "" {
+		s.Server.GET("/metrics", echoprometheus.NewHandler())
+	} else {
+		s.setupMetrics()
+	}
+
+	if s.FrameworkOptions.DynDNS.Enabled() {
+		s.Server.GET(dynDNSPath, s.dynDNSUpdate)
+	}
+
+	s.registerDashboard()
+}
+
+// The function returns how the address of the client is determined: the address of the connection,
+// unless trusted proxies are configured, as the X-Forwarded-For and X-Real-IP headers can be set by
+// anyone. The DynDNS endpoint updates records with it.
+func ipExtractor(trustedProxies []*net.IPNet) echo.IPExtractor {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, network := range trustedProxies {
+		options = append(options, echo.TrustIPRange(network))
+	}
+
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
+// The function parses the trusted proxies, given as addresses or CIDR networks.
+func ParseTrustedProxies(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+
+	for _, value := range values {
+		if address := net.ParseIP(value); address != nil {
+			bits := 8 * net.IPv6len
+			if address.To4() != nil {
+				address, bits = address.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: address, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither an address nor a CIDR network", value)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// The function sets up the plain HTTP listener serving /metrics apart from the API, so scrapers need
+// neither TLS nor client certificates. Credentials are required unless auth.public_metrics is set.
+func (s *Server) setupMetrics() {
+	s.MetricsServer = echo.New()
+
+	s.MetricsServer.HideBanner = true
+	s.MetricsServer.HidePort = true
+	s.MetricsServer.HTTPErrorHandler = httpErrorHandler
+
+	if s.FrameworkOptions.Auth.Enabled() {
+		s.MetricsServer.Use(authMiddleware(s.FrameworkOptions.Auth))
+	}
 
-	s.Server.GET("/metrics", echoprometheus.NewHandler())
+	s.MetricsServer.GET("/metrics", echoprometheus.NewHandler())
 }
@@ -0,0 +1,205 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	"github.com/wasilak/cloudflare-ddns/libs"
+	"github.com/wasilak/cloudflare-ddns/libs/api"
+	"github.com/wasilak/cloudflare-ddns/libs/cf"
+	"github.com/wasilak/cloudflare-ddns/libs/cf/cftest"
+	"github.com/wasilak/cloudflare-ddns/libs/ip"
+)
+
+// testEnv is a server managing the records of a zone of a fake Cloudflare API.
+type testEnv struct {
+	fake   *cftest.Server
+	zoneID string
+	server *httptest.Server
+}
+
+// The function starts a server managing the given names of example.com, which the DynDNS user router
+// with the password secret may update. The globals of the api and ip packages are restored when the
+// test ends.
+func newTestEnv(t *testing.T, names ...string) *testEnv {
+	t.Helper()
+
+	fake := cftest.NewServer()
+	t.Cleanup(fake.Close)
+	zone := fake.AddZone("example.com")
+
+	cfAPI, dnsProvider, records, ipLists, store, current := api.CfAPI, api.DNSProvider, api.Records, api.IPLists, api.Storage, ip.Current()
+	t.Cleanup(func() {
+		api.CfAPI, api.DNSProvider, api.Records, api.IPLists, api.Storage = cfAPI, dnsProvider, records, ipLists, store
+		api.LoadPins(nil)
+		ip.SetCurrent(current)
+	})
+
+	api.CfAPI = &cf.CF{}
+	api.CfAPI.Init("key", "user@example.com", fake.BaseURL())
+	api.DNSProvider = api.CfAPI
+	api.IPLists = &[]cf.IPList{}
+	ip.SetCurrent(&ip.IP{IP: "203.0.113.1"})
+
+	managed := make([]cf.ExtendedCloudflareDNSRecord, 0, len(names))
+	for _, name := range names {
+		managed = append(managed, cf.ExtendedCloudflareDNSRecord{
+			Record:   &dns.RecordResponse{Name: name, Type: "A", TTL: 1, Comment: api.Owner},
+			ZoneName: "example.com",
+		})
+	}
+	api.Records = api.NewRecordStore(managed)
+
+	hash, err := HashSecret("secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dynDNS := DynDNSConfig{Credentials: []DynDNSCredential{{Username: "router", Hash: hash, Hostnames: names}}}
+	s := &Server{WebServer: &WebServer{FrameworkOptions: FrameworkOptions{LogLevelConfig: &slog.LevelVar{}, DynDNS: dynDNS}}}
+	s.setup()
+
+	server := httptest.NewServer(s.Server)
+	t.Cleanup(server.Close)
+
+	return &testEnv{fake: fake, zoneID: zone.ID, server: server}
+}
+
+// The function sends a request to the server and returns the status code, failing the test when the
+// request cannot be sent.
+func (e *testEnv) do(t *testing.T, method, path string, body any) int {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, e.server.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := e.server.Client().Do(req)
+	if err != nil {
+		t.Error(err)
+		return 0
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	return resp.StatusCode
+}
+
+// Runner cycles changing the IP address run while the API is used, the race detector reporting the
+// unsynchronized accesses when the tests run with -race.
+func TestConcurrentAPICallsDuringRunner(t *testing.T) {
+	env := newTestEnv(t, "home.example.com", "vpn.example.com")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The records created by the clients are deleted again, so the cycles only update the managed ones.
+	managed := api.Records.Snapshot()
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer cancel()
+
+		for i := 1; i <= 10; i++ {
+			ip.SetCurrent(&ip.IP{IP: fmt.Sprintf("203.0.113.%d", i)})
+			if _, err := libs.Runner(ctx, managed, api.IPLists); err != nil {
+				t.Errorf("runner %d: %v", i, err)
+			}
+		}
+	}()
+
+	clients := []func(i int){
+		func(int) { env.do(t, http.MethodGet, "/health", nil) },
+		func(int) { env.do(t, http.MethodGet, "/api/list", nil) },
+		func(i int) {
+			name := fmt.Sprintf("client-%d.example.com", i)
+			record := map[string]any{"zone_name": "example.com", "record": map[string]any{"name": name, "type": "A", "ttl": 1}}
+			if status := env.do(t, http.MethodPut, "/api/", record); status != http.StatusCreated {
+				t.Errorf("creating %s: status %d", name, status)
+				return
+			}
+			if status := env.do(t, http.MethodDelete, "/api/example.com/"+name+"?type=A", nil); status != http.StatusOK {
+				t.Errorf("deleting %s: status %d", name, status)
+			}
+		},
+	}
+
+	for _, client := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ctx.Err() == nil; i++ {
+				client(i)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// The cycles ran in order, so the managed records end up with the last address.
+	for _, record := range env.fake.Records(env.zoneID) {
+		if record.Content != "203.0.113.10" {
+			t.Errorf("%s: content %s, want 203.0.113.10", record.Name, record.Content)
+		}
+	}
+}
+
+// Without the type parameter, the legacy deletion matches the managed record with the name whatever
+// its type, and refuses to guess between several types.
+func TestLegacyDeleteWithoutType(t *testing.T) {
+	env := newTestEnv(t, "home.example.com")
+	env.runCycle(t, "203.0.113.1")
+
+	records := []map[string]any{
+		{"zone_name": "example.com", "CNAME": "target.example.net", "record": map[string]any{"name": "www.example.com", "type": "CNAME", "ttl": 1}},
+		{"zone_name": "example.com", "template": "v=spf1 ip4:{{.IP}} -all", "record": map[string]any{"name": "home.example.com", "type": "TXT", "ttl": 1}},
+	}
+	for _, record := range records {
+		if status := env.do(t, http.MethodPut, "/api/", record); status != http.StatusCreated {
+			t.Fatalf("creating %v: status %d", record["record"], status)
+		}
+	}
+
+	if status := env.do(t, http.MethodDelete, "/api/example.com/www.example.com", nil); status != http.StatusOK {
+		t.Errorf("deleting the CNAME record: status %d", status)
+	}
+	if status := env.do(t, http.MethodDelete, "/api/example.com/home.example.com", nil); status != http.StatusUnprocessableEntity {
+		t.Errorf("deleting one of the home.example.com records: status %d, want 422", status)
+	}
+	if status := env.do(t, http.MethodDelete, "/api/example.com/missing.example.com", nil); status != http.StatusNotFound {
+		t.Errorf("deleting a missing record: status %d, want 404", status)
+	}
+
+	types := []string{}
+	for _, record := range env.fake.Records(env.zoneID) {
+		types = append(types, record.Name+" "+record.Type)
+	}
+	if want := []string{"home.example.com A", "home.example.com TXT"}; !slices.Equal(types, want) {
+		t.Errorf("records %v, want %v", types, want)
+	}
+}
@@ -0,0 +1,96 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/wasilak/cloudflare-ddns/libs"
+)
+
+// Readiness states answered by /readyz.
+const (
+	StatusReady    = "ready"
+	StatusNotReady = "not_ready"
+)
+
+// ReadinessResponse is the body answered by /readyz.
+type ReadinessResponse struct {
+	Status              string `json:"status"`
+	Reason              string `json:"reason,omitempty"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+}
+
+// The function answers the liveness probe: the process is up and serving requests. Failing cycles
+// are reported by /readyz, restarting the process would not fix them.
+func (s *Server) livezRoute(c echo.Context) error {
+	return c.JSON(http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// The function answers the readiness probe: not ready until the first cycle completed, and once
+// FailureThreshold cycles failed in a row.
+func (s *Server) readyzRoute(c echo.Context) error {
+	refresher := s.FrameworkOptions.Refresher
+	if refresher == nil {
+		return c.JSON(http.StatusOK, ReadinessResponse{Status: StatusReady})
+	}
+
+	status := refresher.Status()
+	response := ReadinessResponse{Status: StatusReady, ConsecutiveFailures: status.ConsecutiveFailures}
+
+	switch {
+	case status.Cycles == 0:
+		response.Status = StatusNotReady
+		response.Reason = "no cycle completed yet"
+	case s.FrameworkOptions.FailureThreshold > 0 && status.ConsecutiveFailures >= s.FrameworkOptions.FailureThreshold:
+		response.Status = StatusNotReady
+		response.Reason = fmt.Sprintf("the last %d cycles failed", status.ConsecutiveFailures)
+	}
+
+	if response.Status != StatusReady {
+		return c.JSON(http.StatusServiceUnavailable, response)
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// The function answers the detailed status of the daemon, with the records, errors and changes of the
+// zones the credential may access.
+func (s *Server) statusRoute(c echo.Context) error {
+	refresher := s.FrameworkOptions.Refresher
+	if refresher == nil {
+		return c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Code:    CodeUnavailable,
+			Message: "Status not available",
+			Error:   "refresh cycles are only run by the daemon",
+		})
+	}
+
+	status := refresher.Status()
+
+	records := make([]libs.RecordStatus, 0, len(status.Records))
+	for _, record := range status.Records {
+		if allowsZone(c, record.ZoneName) {
+			records = append(records, record)
+		}
+	}
+	status.Records = records
+
+	recentErrors := make([]libs.StatusError, 0, len(status.RecentErrors))
+	for _, statusError := range status.RecentErrors {
+		if statusError.ZoneName == "" || allowsZone(c, statusError.ZoneName) {
+			recentErrors = append(recentErrors, statusError)
+		}
+	}
+	status.RecentErrors = recentErrors
+
+	history := make([]libs.HistoryEntry, 0, len(status.History))
+	for _, entry := range status.History {
+		if entry.ZoneName == "" || allowsZone(c, entry.ZoneName) {
+			history = append(history, entry)
+		}
+	}
+	status.History = history
+
+	return c.JSON(http.StatusOK, status)
+}
@@ -0,0 +1,203 @@
+package web
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval bounds how often the TLS files are checked for changes.
+const certCheckInterval = 5 * time.Second
+
+// TLSConfig configures HTTPS. TLS is enabled as soon as a certificate is defined. The files are
+// reloaded when they change, so renewed certificates are picked up without a restart.
+type TLSConfig struct {
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	// ClientCAFile, when set, requires clients to present a certificate signed by one of the CAs of
+	// this PEM bundle (mutual TLS).
+	ClientCAFile string `mapstructure:"client_ca_file"`
+	// ClientSubjects, when not empty, only accepts the client certificates matching one of them: the
+	// full subject (e.g. "CN=router,O=home"), its common name, or one of its DNS, email or URI names.
+	ClientSubjects []string `mapstructure:"client_subjects"`
+}
+
+// The function reports whether TLS is enabled.
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+// The function checks the settings and that the files can be loaded.
+func (t TLSConfig) Validate() error {
+	if !t.Enabled() {
+		if t.ClientCAFile != "" || len(t.ClientSubjects) > 0 {
+			return fmt.Errorf("client certificates require cert_file and key_file")
+		}
+		return nil
+	}
+
+	if t.CertFile == "" || t.KeyFile == "" {
+		return fmt.Errorf("both cert_file and key_file are required")
+	}
+
+	if len(t.ClientSubjects) > 0 && t.ClientCAFile == "" {
+		return fmt.Errorf("client_subjects requires client_ca_file")
+	}
+
+	_, err := newCertReloader(t)
+	return err
+}
+
+// The function returns the configuration of the HTTPS server, loading the certificate and client CAs
+// again whenever their files change.
+func (t TLSConfig) serverConfig() (*tls.Config, error) {
+	reloader, err := newCertReloader(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return reloader.config(), nil
+		},
+	}, nil
+}
+
+// certReloader holds the certificate and client CAs loaded from the files of a TLSConfig.
+type certReloader struct {
+	settings TLSConfig
+
+	mu          sync.Mutex
+	certificate tls.Certificate
+	clientCAs   *x509.CertPool
+	modTimes    map[string]time.Time
+	checked     time.Time
+}
+
+func newCertReloader(settings TLSConfig) (*certReloader, error) {
+	reloader := &certReloader{settings: settings}
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// The function returns the configuration for a new connection, after reloading the files when they
+// changed. A file that fails to load is logged and the previous certificate is kept.
+func (r *certReloader) config() *tls.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) >= certCheckInterval {
+		r.checked = time.Now()
+		if r.changed() {
+			if err := r.load(); err != nil {
+				slog.Error("Failed to reload the TLS certificate, keeping the previous one", "error", err)
+			} else {
+				slog.Info("TLS certificate reloaded", "cert_file", r.settings.CertFile)
+			}
+		}
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{r.certificate},
+	}
+
+	if r.clientCAs != nil {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = r.clientCAs
+		if len(r.settings.ClientSubjects) > 0 {
+			config.VerifyConnection = r.verifySubject
+		}
+	}
+
+	return config
+}
+
+// The function loads the files. The caller holds mu, or is the constructor.
+func (r *certReloader) load() error {
+	modTimes := map[string]time.Time{}
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[file] = info.ModTime()
+	}
+	// Broken files are only loaded again once they change, rather than on every check.
+	r.modTimes = modTimes
+
+	certificate, err := tls.LoadX509KeyPair(r.settings.CertFile, r.settings.KeyFile)
+	if err != nil {
+		return fmt.Errorf("loading certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.settings.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.settings.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("loading client CAs: %w", err)
+		}
+
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("loading client CAs: no certificate found in %s", r.settings.ClientCAFile)
+		}
+	}
+
+	r.certificate = certificate
+	r.clientCAs = clientCAs
+
+	return nil
+}
+
+// The function reports whether one of the files changed since it was loaded. The caller holds mu.
+func (r *certReloader) changed() bool {
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil || !info.ModTime().Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.settings.CertFile, r.settings.KeyFile}
+	if r.settings.ClientCAFile != "" {
+		files = append(files, r.settings.ClientCAFile)
+	}
+	return files
+}
+
+// The function accepts the connection when the client certificate matches one of the allowed
+// subjects. The chain has already been verified against the client CAs.
+func (r *certReloader) verifySubject(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("no client certificate")
+	}
+
+	leaf := state.PeerCertificates[0]
+	names := []string{leaf.Subject.String(), leaf.Subject.CommonName}
+	names = append(names, leaf.DNSNames...)
+	names = append(names, leaf.EmailAddresses...)
+	for _, uri := range leaf.URIs {
+		names = append(names, uri.String())
+	}
+
+	for _, allowed := range r.settings.ClientSubjects {
+		for _, name := range names {
+			if name != "" && name == allowed {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("client certificate %q is not allowed", leaf.Subject.String())
+}
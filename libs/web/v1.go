@@ -0,0 +1,525 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	"github.com/labstack/echo/v4"
+	"github.com/wasilak/cloudflare-ddns/libs/api"
+	"github.com/wasilak/cloudflare-ddns/libs/cf"
+	"github.com/wasilak/cloudflare-ddns/libs/events"
+	"github.com/wasilak/cloudflare-ddns/libs/ip"
+)
+
+// Pagination defaults and limits of the v1 lists.
+const (
+	defaultPerPage = 50
+	maxPerPage     = 500
+)
+
+// The function registers the v1 routes: zones, and the records of a zone addressed by name and type.
+func (s *Server) registerV1(g *echo.Group) {
+	g.GET("/zones", s.v1ListZones)
+	g.GET("/zones/:zone/records", s.v1ListRecords)
+	g.POST("/zones/:zone/records", s.v1CreateRecord)
+	g.GET("/zones/:zone/records/:name/:type", s.v1GetRecord)
+	g.PUT("/zones/:zone/records/:name/:type", s.v1ReplaceRecord)
+	g.PATCH("/zones/:zone/records/:name/:type", s.v1PatchRecord)
+	g.DELETE("/zones/:zone/records/:name/:type", s.v1DeleteRecord)
+	g.DELETE("/zones/:zone/records/:name/:type/pin", s.v1ReleasePin)
+}
+
+// The function lists the zones of the DNS provider the credential may access, with the number of
+// managed records in each. The name query parameter keeps the zones containing it.
+func (s *Server) v1ListZones(c echo.Context) error {
+	page, perPage, err := pageParams(c)
+	if err != nil {
+		return err
+	}
+
+	names, err := api.DNSProvider.ListZones(c.Request().Context())
+	if err != nil {
+		return c.JSON(errorStatus(err), errorResponse("Zones not listed", err))
+	}
+
+	counts := map[string]int{}
+	for _, record := range api.Records.Snapshot() {
+		counts[record.ZoneName]++
+	}
+
+	filter := strings.ToLower(c.QueryParam("name"))
+	zones := make([]Zone, 0, len(names))
+	for _, name := range names {
+		if !allowsZone(c, name) || !strings.Contains(strings.ToLower(name), filter) {
+			continue
+		}
+		zones = append(zones, Zone{Name: name, Records: counts[name]})
+	}
+
+	zones, pagination := paginate(zones, page, perPage)
+	return c.JSON(http.StatusOK, ZoneList{Zones: zones, Pagination: pagination})
+}
+
+// The function lists the managed records of the zone. The name query parameter keeps the records
+// whose name contains it, type and origin the records matching them exactly. With live=true, the state
+// of each record at the DNS provider is fetched and compared to the desired one.
+func (s *Server) v1ListRecords(c echo.Context) error {
+	zoneName, err := s.v1Zone(c)
+	if err != nil {
+		return err
+	}
+
+	page, perPage, err := pageParams(c)
+	if err != nil {
+		return err
+	}
+
+	name := strings.ToLower(c.QueryParam("name"))
+	recordType := strings.ToUpper(c.QueryParam("type"))
+	origin := c.QueryParam("origin")
+
+	records := make([]cf.ExtendedCloudflareDNSRecord, 0)
+	for _, record := range api.Records.Snapshot() {
+		switch {
+		case record.ZoneName != zoneName,
+			!strings.Contains(record.Record.Name, name),
+			recordType != "" && string(record.Record.Type) != recordType,
+			origin != "" && record.Origin != origin:
+			continue
+		}
+		records = append(records, record)
+	}
+
+	records, pagination := paginate(records, page, perPage)
+
+	states := make([]RecordState, 0, len(records))
+	for _, record := range records {
+		states = append(states, recordState(record))
+	}
+
+	if c.QueryParam("live") == "true" {
+		if err := liveStates(c.Request().Context(), zoneName, states); err != nil {
+			return c.JSON(errorStatus(err), errorResponse("Live state not available", err))
+		}
+	}
+
+	return c.JSON(http.StatusOK, RecordList{Records: states, Pagination: pagination})
+}
+
+// The function fetches the records of the zone from the DNS provider and sets the live state of the
+// managed ones, matched by ID or else by name, type and comment.
+func liveStates(ctx context.Context, zoneName string, states []RecordState) error {
+	zoneID, err := api.DNSProvider.GetZoneID(ctx, zoneName)
+	if err != nil {
+		return err
+	}
+
+	providerRecords, err := api.DNSProvider.ListDNSRecords(ctx, zoneID)
+	if err != nil {
+		return err
+	}
+
+	for i := range states {
+		managed := states[i].Record
+		live := &LiveState{}
+
+		for _, providerRecord := range providerRecords {
+			candidate := providerRecord.Record
+			sameID := managed.ID != "" && candidate.ID == managed.ID
+			sameRecord := managed.ID == "" && candidate.Name == managed.Name && candidate.Type == managed.Type && candidate.Comment == managed.Comment
+			if !sameID && !sameRecord {
+				continue
+			}
+
+			live.Found = true
+			live.Content = candidate.Content
+			live.Proxied = candidate.Proxied
+			live.TTL = float64(candidate.TTL)
+			break
+		}
+
+		desired := managed.Content
+		if ip.Current() != nil {
+			if content, err := api.DesiredContent(states[i].ExtendedCloudflareDNSRecord); err == nil {
+				desired = content
+			}
+		}
+		live.InSync = live.Found && live.Content == desired && live.Proxied == managed.Proxied
+
+		states[i].Live = live
+	}
+
+	return nil
+}
+
+// The function returns the managed record of the zone with the name and type of the path.
+func (s *Server) v1GetRecord(c echo.Context) error {
+	record, err := s.v1Record(c)
+	if err != nil {
+		return err
+	}
+
+	managed := api.FindDNSRecord(record.ZoneName, record.Record.Name, string(record.Record.Type))
+	if managed == nil {
+		return recordNotFound(c, record)
+	}
+
+	return c.JSON(http.StatusOK, recordState(*managed))
+}
+
+// The function creates a record in the zone of the path and answers it with its location. A record
+// already managed under the same name, type and comment is a conflict.
+func (s *Server) v1CreateRecord(c echo.Context) error {
+	zoneName, err := s.v1Zone(c)
+	if err != nil {
+		return err
+	}
+
+	record := cf.ExtendedCloudflareDNSRecord{}
+	if err := c.Bind(&record); err != nil {
+		return invalidRequest(c, err)
+	}
+
+	if err := matchZone(&record, zoneName); err != nil {
+		return c.JSON(errorStatus(err), errorResponse("Record not created", err))
+	}
+	if record.Record.Comment == "" {
+		record.Record.Comment = api.Owner
+	}
+
+	if _, ok := api.Records.Get(record.Key()); ok {
+		return c.JSON(http.StatusConflict, ErrorResponse{
+			Code:       CodeConflict,
+			Message:    "Record not created",
+			Error:      "record is already managed",
+			RecordName: record.Record.Name,
+			ZoneName:   record.ZoneName,
+		})
+	}
+
+	// Records created through the API are persisted, whatever origin the client claims.
+	record.Origin = cf.OriginAPI
+
+	_, err = api.AddRecord(c.Request().Context(), &record)
+	publishRecordChange(events.TypeRecordCreated, record, "", err)
+	if err != nil {
+		return c.JSON(errorStatus(err), errorResponse("Record not created", err))
+	}
+
+	c.Response().Header().Set(echo.HeaderLocation, recordLocation(record))
+	return c.JSON(http.StatusCreated, managedRecord(record))
+}
+
+// The function replaces the record of the path with the request body. Omitted fields take their
+// default values, an omitted content follows the template, CNAME or IP as on creation.
+func (s *Server) v1ReplaceRecord(c echo.Context) error {
+	target, err := s.v1Record(c)
+	if err != nil {
+		return err
+	}
+
+	record := cf.ExtendedCloudflareDNSRecord{}
+	if err := c.Bind(&record); err != nil {
+		return invalidRequest(c, err)
+	}
+
+	return s.v1UpdateRecord(c, target, record)
+}
+
+// The function applies the request body to the managed record of the path as a JSON merge patch
+// (RFC 7386): fields present replace the current ones and null removes them. The content follows the
+// template, CNAME or IP again unless the patch sets it.
+func (s *Server) v1PatchRecord(c echo.Context) error {
+	target, err := s.v1Record(c)
+	if err != nil {
+		return err
+	}
+
+	managed := api.FindDNSRecord(target.ZoneName, target.Record.Name, string(target.Record.Type))
+	if managed == nil {
+		return recordNotFound(c, target)
+	}
+
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return invalidRequest(c, err)
+	}
+
+	var patch map[string]any
+	if err := json.Unmarshal(body, &patch); err != nil {
+		return invalidRequest(c, err)
+	}
+
+	current, err := json.Marshal(managed)
+	if err != nil {
+		return err
+	}
+
+	var document map[string]any
+	if err := json.Unmarshal(current, &document); err != nil {
+		return err
+	}
+
+	// The content was derived for the current record, derive it again unless the patch sets it.
+	if recordDocument, ok := document["record"].(map[string]any); ok {
+		delete(recordDocument, "content")
+	}
+
+	patched, err := json.Marshal(mergePatch(document, patch))
+	if err != nil {
+		return err
+	}
+
+	record := cf.ExtendedCloudflareDNSRecord{}
+	if err := json.Unmarshal(patched, &record); err != nil {
+		return invalidRequest(c, err)
+	}
+
+	return s.v1UpdateRecord(c, target, record)
+}
+
+// The function updates the target record with the given definition, refusing to move it to another
+// zone, name or type. The name and type default to the ones of the target.
+func (s *Server) v1UpdateRecord(c echo.Context, target, record cf.ExtendedCloudflareDNSRecord) error {
+	if record.Record == nil {
+		record.Record = &dns.RecordResponse{}
+	}
+	if record.Record.Name == "" {
+		record.Record.Name = target.Record.Name
+	}
+	if record.Record.Type == "" {
+		record.Record.Type = target.Record.Type
+	}
+
+	if err := matchZone(&record, target.ZoneName); err != nil {
+		return c.JSON(errorStatus(err), errorResponse("Record not updated", err))
+	}
+
+	if record.Record.Name != target.Record.Name || record.Record.Type != target.Record.Type {
+		err := fmt.Errorf("%w: record name and type must match the path %s %s", cf.ErrValidation, target.Record.Name, target.Record.Type)
+		return c.JSON(errorStatus(err), errorResponse("Record not updated", err))
+	}
+
+	// The origin of a managed record is kept, see api.UpdateRecord.
+	record.Origin = ""
+
+	previous, err := api.UpdateRecord(c.Request().Context(), &record)
+	publishRecordChange(events.TypeRecordUpdated, record, recordContent(previous), err)
+	if err != nil {
+		return c.JSON(errorStatus(err), errorResponse("Record not updated", err))
+	}
+
+	return c.JSON(http.StatusOK, managedRecord(record))
+}
+
+// The function deletes the record of the path.
+func (s *Server) v1DeleteRecord(c echo.Context) error {
+	record, err := s.v1Record(c)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := api.DeleteRecord(c.Request().Context(), record.Record.Name, record.ZoneName, string(record.Record.Type))
+	publishRecordChange(events.TypeRecordDeleted, record, recordContent(deleted), err)
+	if err != nil {
+		response := errorResponse("Record not deleted", err)
+		response.RecordName = record.Record.Name
+		response.ZoneName = record.ZoneName
+		return c.JSON(errorStatus(err), response)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// The function releases the pin a DynDNS update set on the record of the path, which follows its
+// template, CNAME or the detected IP again. Releasing a record that is not pinned does nothing.
+func (s *Server) v1ReleasePin(c echo.Context) error {
+	record, err := s.v1Record(c)
+	if err != nil {
+		return err
+	}
+
+	managed := api.FindDNSRecord(record.ZoneName, record.Record.Name, string(record.Record.Type))
+	if managed == nil {
+		return recordNotFound(c, record)
+	}
+
+	if err := api.ReleasePin(c.Request().Context(), *managed); err != nil {
+		response := errorResponse("Pin not released", err)
+		response.RecordName = record.Record.Name
+		response.ZoneName = record.ZoneName
+		return c.JSON(errorStatus(err), response)
+	}
+
+	return c.JSON(http.StatusOK, managedRecord(*managed))
+}
+
+// The function returns the normalized zone of the path, after checking the credential may access it.
+func (s *Server) v1Zone(c echo.Context) (string, error) {
+	zoneName, err := cf.NormalizeZoneName(c.Param("zone"))
+	if err != nil {
+		err = fmt.Errorf("%w: %w", cf.ErrValidation, err)
+		return "", apiError(errorStatus(err), errorResponse("Invalid zone", err))
+	}
+
+	if !allowsZone(c, zoneName) {
+		return "", apiError(http.StatusForbidden, forbiddenZoneResponse(zoneName))
+	}
+
+	return zoneName, nil
+}
+
+// The function returns a record holding the normalized zone, name and type of the path.
+func (s *Server) v1Record(c echo.Context) (cf.ExtendedCloudflareDNSRecord, error) {
+	zoneName, err := s.v1Zone(c)
+	if err != nil {
+		return cf.ExtendedCloudflareDNSRecord{}, err
+	}
+
+	record := cf.ExtendedCloudflareDNSRecord{
+		Record: &dns.RecordResponse{
+			Name: c.Param("name"),
+			Type: dns.RecordResponseType(c.Param("type")),
+		},
+		ZoneName: zoneName,
+	}
+
+	if err := record.Normalize(); err != nil {
+		err = fmt.Errorf("%w: %w", cf.ErrValidation, err)
+		return record, apiError(errorStatus(err), errorResponse("Invalid record", err))
+	}
+
+	return record, nil
+}
+
+// The function returns the error answering the request with the response, see httpErrorHandler.
+func apiError(status int, response ErrorResponse) *echo.HTTPError {
+	return echo.NewHTTPError(status, response)
+}
+
+// The function sets the zone of the record to the one of the path, refusing another zone in the body,
+// and normalizes the record.
+func matchZone(record *cf.ExtendedCloudflareDNSRecord, zoneName string) error {
+	if record.Record == nil {
+		return fmt.Errorf("%w: record definition is missing", cf.ErrValidation)
+	}
+
+	if record.ZoneName != "" {
+		bodyZone, err := cf.NormalizeZoneName(record.ZoneName)
+		if err != nil || bodyZone != zoneName {
+			return fmt.Errorf("%w: zone_name %q does not match the path zone %s", cf.ErrValidation, record.ZoneName, zoneName)
+		}
+	}
+
+	record.ZoneName = zoneName
+	if err := record.Normalize(); err != nil {
+		return fmt.Errorf("%w: %w", cf.ErrValidation, err)
+	}
+
+	return nil
+}
+
+// The function returns the record as managed after a change, or the given one when it is not in the
+// store, e.g. in dry-run mode.
+func managedRecord(record cf.ExtendedCloudflareDNSRecord) RecordState {
+	if managed, ok := api.Records.Get(record.Key()); ok {
+		return recordState(managed)
+	}
+	return recordState(record)
+}
+
+// The function returns the state of the managed record, with the content it is pinned to, if any.
+func recordState(record cf.ExtendedCloudflareDNSRecord) RecordState {
+	pinned, _ := api.PinnedContent(record.Key())
+	return RecordState{ExtendedCloudflareDNSRecord: record, Pinned: pinned}
+}
+
+// The function returns the v1 URL of the record.
+func recordLocation(record cf.ExtendedCloudflareDNSRecord) string {
+	return "/api/v1/zones/" + url.PathEscape(record.ZoneName) + "/records/" + url.PathEscape(record.Record.Name) + "/" + url.PathEscape(string(record.Record.Type))
+}
+
+func recordNotFound(c echo.Context, record cf.ExtendedCloudflareDNSRecord) error {
+	err := fmt.Errorf("%w: %s %s", cf.ErrRecordNotFound, record.Record.Name, record.Record.Type)
+	response := errorResponse("Record not found", err)
+	response.RecordName = record.Record.Name
+	response.ZoneName = record.ZoneName
+	return c.JSON(errorStatus(err), response)
+}
+
+func invalidRequest(c echo.Context, err error) error {
+	return c.JSON(http.StatusBadRequest, ErrorResponse{Code: CodeInvalidRequest, Message: "Invalid request", Error: err.Error()})
+}
+
+// The function returns the page and per_page query parameters, 1 and defaultPerPage when omitted.
+func pageParams(c echo.Context) (int, int, error) {
+	page, perPage := 1, defaultPerPage
+
+	for name, value := range map[string]*int{"page": &page, "per_page": &perPage} {
+		raw := c.QueryParam(name)
+		if raw == "" {
+			continue
+		}
+
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || (name == "per_page" && parsed > maxPerPage) {
+			return 0, 0, apiError(http.StatusBadRequest, ErrorResponse{
+				Code:    CodeInvalidRequest,
+				Message: "Invalid request",
+				Error:   fmt.Sprintf("invalid %s %q", name, raw),
+			})
+		}
+		*value = parsed
+	}
+
+	return page, perPage, nil
+}
+
+// The function returns the requested page of items and its description.
+func paginate[T any](items []T, page, perPage int) ([]T, Pagination) {
+	pagination := Pagination{
+		Page:       page,
+		PerPage:    perPage,
+		Total:      len(items),
+		TotalPages: (len(items) + perPage - 1) / perPage,
+	}
+
+	// Pages past the last one are empty. Comparing with a division keeps huge page numbers from
+	// overflowing (page-1)*perPage.
+	if page > len(items)/perPage+1 {
+		return make([]T, 0), pagination
+	}
+
+	start := (page - 1) * perPage
+	end := min(start+perPage, len(items))
+
+	return items[start:end], pagination
+}
+
+// The function applies a JSON merge patch (RFC 7386) to the document.
+func mergePatch(document, patch map[string]any) map[string]any {
+	for key, value := range patch {
+		if value == nil {
+			delete(document, key)
+			continue
+		}
+
+		patchObject, isObject := value.(map[string]any)
+		documentObject, wasObject := document[key].(map[string]any)
+		if isObject && wasObject {
+			document[key] = mergePatch(documentObject, patchObject)
+			continue
+		}
+
+		document[key] = value
+	}
+
+	return document
+}
@@ -0,0 +1,44 @@
+package web
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestPaginate(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+
+	tests := []struct {
+		page, perPage int
+		want          int
+	}{
+		{page: 1, perPage: 2, want: 2},
+		{page: 3, perPage: 2, want: 1},
+		{page: 4, perPage: 2, want: 0},
+		{page: 2, perPage: 5, want: 0},
+		{page: math.MaxInt, perPage: 50, want: 0},
+		{page: math.MaxInt/50 + 2, perPage: 50, want: 0},
+	}
+
+	for _, tt := range tests {
+		got, pagination := paginate(items, tt.page, tt.perPage)
+		if len(got) != tt.want || got == nil {
+			t.Errorf("page %d of %d: %v, want %d items", tt.page, tt.perPage, got, tt.want)
+		}
+		if pagination.Total != len(items) {
+			t.Errorf("page %d of %d: total %d", tt.page, tt.perPage, pagination.Total)
+		}
+	}
+}
+
+func TestHugePageIsEmpty(t *testing.T) {
+	env := newTestEnv(t, "203.0.113.1", "home.example.com")
+
+	for _, page := range []string{"184467440737095518", strconv.Itoa(math.MaxInt)} {
+		if status := env.do(t, http.MethodGet, "/api/v1/zones/example.com/records?page="+page+"&per_page=50", nil); status != http.StatusOK {
+			t.Errorf("page %s: status %d, want %d", page, status, http.StatusOK)
+		}
+	}
+}